@@ -2,6 +2,8 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
@@ -11,10 +13,14 @@ import (
 	"time"
 
 	"chchma.com/cloudlite-sync/config"
+	"chchma.com/cloudlite-sync/internal/compress"
 	"chchma.com/cloudlite-sync/internal/controller"
 	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/envelope"
 	"chchma.com/cloudlite-sync/internal/oss"
+	"chchma.com/cloudlite-sync/internal/s3"
 	"chchma.com/cloudlite-sync/internal/session"
+	"chchma.com/cloudlite-sync/internal/storage"
 )
 
 func main() {
@@ -37,34 +43,56 @@ func main() {
 	}
 	defer db.Close()
 
+	keys, err := envelope.NewKeyring(cfg.Encryption.MasterKeys, cfg.Encryption.ActiveKey)
+	if err != nil {
+		log.Fatalf("Invalid encryption config: %v", err)
+	}
+
+	// 管理命令：rewrap-keys 用当前主密钥重新包装所有项目数据密钥
+	if len(os.Args) > 1 && os.Args[1] == "rewrap-keys" {
+		if err := rewrapKeys(db, keys); err != nil {
+			log.Fatalf("Failed to rewrap keys: %v", err)
+		}
+		return
+	}
+	if keys.Enabled() {
+		log.Printf("Encrypting new versions with master key %s", keys.ActiveID())
+	}
+
 	// 初始化分享码服务配置
 	shareService := session.GetShareCodeService()
 	shareService.SetExpireSeconds(cfg.ShareCode.ExpireSeconds)
 	log.Printf("Share code expire time set to %d seconds", cfg.ShareCode.ExpireSeconds)
 
-	// 初始化OSS客户端
-	var ossClient *oss.OSSClient
-	if cfg.OSS.Endpoint != "" && cfg.OSS.AccessKeyID != "" && cfg.OSS.AccessKeySecret != "" {
-		ossClient, err = oss.NewOSSClient(oss.OSSConfig{
-			Endpoint:        cfg.OSS.Endpoint,
-			AccessKeyID:     cfg.OSS.AccessKeyID,
-			AccessKeySecret: cfg.OSS.AccessKeySecret,
-			BucketName:      cfg.OSS.BucketName,
-		})
-		if err != nil {
-			log.Fatalf("Failed to initialize OSS client: %v", err)
+	// 初始化存储后端
+	store, err := newStorage(cfg)
+	if err != nil {
+		log.Fatalf("Failed to initialize storage: %v", err)
+	}
+	cfg.Storage.Compression, err = compress.Normalize(cfg.Storage.Compression)
+	if err != nil {
+		log.Fatalf("Invalid storage compression: %v", err)
+	}
+	if cfg.Storage.Compression != compress.None {
+		log.Printf("Compressing new versions with %s", cfg.Storage.Compression)
+	}
+
+	// 管理命令：reconcile 检查存储与数据库记录的一致性，--repair 时修复发现的问题
+	if len(os.Args) > 1 && os.Args[1] == "reconcile" {
+		if err := reconcile(cfg, db, store, keys, os.Args[2:]); err != nil {
+			log.Fatalf("Reconcile failed: %v", err)
 		}
-	} else {
-		log.Println("Warning: OSS configuration not provided, file operations will be disabled")
+		return
 	}
 
 	// 创建HTTP服务器
 	srv := &http.Server{
-		Addr:         fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port),
-		Handler:      controller.NewRouter(cfg, db, ossClient),
-		ReadTimeout:  15 * time.Second,
-		WriteTimeout: 15 * time.Second,
-		IdleTimeout:  60 * time.Second,
+		Addr:              fmt.Sprintf("%s:%s", cfg.Server.Host, cfg.Server.Port),
+		Handler:           controller.NewRouter(cfg, db, store, keys),
+		ReadTimeout:       time.Duration(cfg.Server.ReadTimeout) * time.Second,
+		ReadHeaderTimeout: 15 * time.Second,
+		WriteTimeout:      time.Duration(cfg.Server.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.Server.IdleTimeout) * time.Second,
 	}
 
 	// 启动服务器
@@ -92,3 +120,111 @@ func main() {
 
 	log.Println("Server exited")
 }
+
+// newStorage 根据配置创建存储后端，未指定驱动时有 OSS 配置则使用 OSS，否则使用本地目录
+func newStorage(cfg *config.Config) (storage.Storage, error) {
+	driver := cfg.Storage.Driver
+	if driver == "" {
+		if cfg.OSS.Endpoint != "" && cfg.OSS.AccessKeyID != "" && cfg.OSS.AccessKeySecret != "" {
+			driver = storage.DriverOSS
+		} else {
+			driver = storage.DriverLocal
+		}
+	}
+
+	switch driver {
+	case storage.DriverOSS:
+		log.Printf("Using OSS storage, bucket: %s", cfg.OSS.BucketName)
+		return oss.NewOSSClient(oss.OSSConfig{
+			Endpoint:        cfg.OSS.Endpoint,
+			AccessKeyID:     cfg.OSS.AccessKeyID,
+			AccessKeySecret: cfg.OSS.AccessKeySecret,
+			BucketName:      cfg.OSS.BucketName,
+		})
+	case storage.DriverS3:
+		log.Printf("Using S3 storage, endpoint: %s, bucket: %s", cfg.OSS.Endpoint, cfg.OSS.BucketName)
+		return s3.NewS3Client(s3.S3Config{
+			Endpoint:        cfg.OSS.Endpoint,
+			Region:          cfg.OSS.Region,
+			AccessKeyID:     cfg.OSS.AccessKeyID,
+			AccessKeySecret: cfg.OSS.AccessKeySecret,
+			BucketName:      cfg.OSS.BucketName,
+			PathStyle:       cfg.OSS.PathStyle,
+		})
+	case storage.DriverLocal:
+		log.Printf("Using local storage, directory: %s", cfg.Storage.LocalDir)
+		return storage.NewLocalStorage(cfg.Storage.LocalDir)
+	default:
+		return nil, fmt.Errorf("unknown storage driver: %s", driver)
+	}
+}
+
+// rewrapKeys 用当前主密钥重新包装使用其他主密钥的项目数据密钥，
+// 轮换主密钥时先将新密钥设为当前密钥并执行此命令，之后才能移除旧密钥
+func rewrapKeys(db *database.DB, keys *envelope.Keyring) error {
+	if !keys.Enabled() {
+		return fmt.Errorf("encryption is not enabled")
+	}
+	projectKeys, err := db.ListProjectKeys()
+	if err != nil {
+		return err
+	}
+
+	rewrapped := 0
+	for _, key := range projectKeys {
+		if key.MasterKeyID == keys.ActiveID() {
+			continue
+		}
+		dataKey, err := keys.Unwrap(key.MasterKeyID, key.WrappedKey)
+		if err != nil {
+			return fmt.Errorf("project key %s: %w", key.ID, err)
+		}
+		masterKeyID, wrapped, err := keys.Wrap(dataKey)
+		if err != nil {
+			return fmt.Errorf("project key %s: %w", key.ID, err)
+		}
+		if err := db.UpdateProjectKeyWrap(key.ID, masterKeyID, wrapped); err != nil {
+			return err
+		}
+		rewrapped++
+	}
+	log.Printf("Rewrapped %d of %d project keys with master key %s", rewrapped, len(projectKeys), keys.ActiveID())
+	return nil
+}
+
+// reconcile 执行一次存储一致性检查，将报告以 JSON 输出到标准输出。
+// 存在未修复的问题或无法完成检查的对象时返回错误，便于在定时任务中告警
+func reconcile(cfg *config.Config, db *database.DB, store storage.Storage, keys *envelope.Keyring, args []string) error {
+	flags := flag.NewFlagSet("reconcile", flag.ContinueOnError)
+	repair := flags.Bool("repair", false, "delete orphaned objects, flag broken versions, drop missing deltas and fix blob reference counts")
+	verifyHash := flags.Bool("verify-hash", false, "read every object and verify its content hash")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	report, err := controller.Reconcile(cfg, db, store, keys, controller.ReconcileOptions{
+		Repair:     *repair,
+		VerifyHash: *verifyHash,
+	})
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(report); err != nil {
+		return err
+	}
+
+	unresolved := 0
+	for _, issue := range report.Issues {
+		if !issue.Repaired {
+			unresolved++
+		}
+	}
+	log.Printf("Scanned %d objects and %d versions, found %d issues (%d unresolved), %d errors",
+		report.ObjectsScanned, report.VersionsChecked, len(report.Issues), unresolved, len(report.Errors))
+	if unresolved > 0 || len(report.Errors) > 0 {
+		return fmt.Errorf("%d unresolved issues, %d errors", unresolved, len(report.Errors))
+	}
+	return nil
+}
@@ -4,26 +4,89 @@ import (
 	"encoding/json"
 	"os"
 	"strconv"
+	"strings"
 )
 
 type Config struct {
-	Server        ServerConfig    `json:"server"`
-	OSS           OSSConfig       `json:"oss"`
-	Admin         AdminConfig     `json:"admin"`
-	SessionSecret string          `json:"session_secret"`
-	ShareCode     ShareCodeConfig `json:"share_code"`
+	Server        ServerConfig     `json:"server"`
+	OSS           OSSConfig        `json:"oss"`
+	Storage       StorageConfig    `json:"storage"`
+	Upload        UploadConfig     `json:"upload"`
+	Encryption    EncryptionConfig `json:"encryption"`
+	Retention     RetentionConfig  `json:"retention"`
+	Reconcile     ReconcileConfig  `json:"reconcile"`
+	Trash         TrashConfig      `json:"trash"`
+	Credential    CredentialConfig `json:"credential"`
+	Admin         AdminConfig      `json:"admin"`
+	SessionSecret string           `json:"session_secret"`
+	ShareCode     ShareCodeConfig  `json:"share_code"`
 }
 
 type ServerConfig struct {
-	Port string `json:"port"`
-	Host string `json:"host"`
+	Port         string `json:"port"`
+	Host         string `json:"host"`
+	ReadTimeout  int    `json:"read_timeout"`  // 秒，需覆盖大文件上传耗时
+	WriteTimeout int    `json:"write_timeout"` // 秒，需覆盖大文件下载耗时
+	IdleTimeout  int    `json:"idle_timeout"`  // 秒
+	// TrustedProxies 可信反向代理的地址段，来自这些地址的请求按 X-Forwarded-For 识别客户端地址
+	TrustedProxies []string `json:"trusted_proxies"`
 }
 
+// OSSConfig 对象存储配置，阿里云OSS与S3兼容驱动共用
 type OSSConfig struct {
 	Endpoint        string `json:"endpoint"`
 	AccessKeyID     string `json:"access_key_id"`
 	AccessKeySecret string `json:"access_key_secret"`
 	BucketName      string `json:"bucket_name"`
+	Region          string `json:"region"`     // 仅 s3 驱动使用
+	PathStyle       bool   `json:"path_style"` // 仅 s3 驱动使用，MinIO 等需要开启
+}
+
+// StorageConfig 存储后端配置
+type StorageConfig struct {
+	Driver            string `json:"driver"`              // oss、s3 或 local，为空时根据 OSS 配置自动选择
+	LocalDir          string `json:"local_dir"`           // local 驱动的文件目录
+	SignExpireSeconds int    `json:"sign_expire_seconds"` // 预签名下载地址的有效期
+	Compression       string `json:"compression"`         // 新版本写入存储时的压缩算法：gzip、zstd，为空不压缩
+}
+
+// UploadConfig 上传配置
+type UploadConfig struct {
+	MaxSizeMB int64 `json:"max_size_mb"` // 单个数据库文件大小上限
+	MemoryMB  int64 `json:"memory_mb"`   // 解析表单时驻留内存的上限，超出部分写入临时文件
+	// IntegrityCheck 上传时的完整性检查：quick（默认）、full 或 off（只检查文件头）
+	IntegrityCheck string `json:"integrity_check"`
+	// SessionTTLHours 分片上传会话在最后一次上传分片后的有效小时数，过期后由后台清理任务删除分片，0 表示不过期
+	SessionTTLHours int `json:"session_ttl_hours"`
+}
+
+// EncryptionConfig 存储加密配置，未配置主密钥时不加密
+type EncryptionConfig struct {
+	MasterKeys map[string]string `json:"master_keys"` // 主密钥ID到 base64 编码的 32 字节密钥
+	ActiveKey  string            `json:"active_key"`  // 包装新数据密钥使用的主密钥ID，只有一个主密钥时可省略
+}
+
+// RetentionConfig 版本保留策略后台任务配置
+type RetentionConfig struct {
+	IntervalMinutes int `json:"interval_minutes"` // 执行间隔，0 表示不自动清理
+}
+
+// ReconcileConfig 存储一致性检查后台任务配置
+type ReconcileConfig struct {
+	IntervalMinutes int  `json:"interval_minutes"` // 执行间隔，0 表示不自动检查
+	Repair          bool `json:"repair"`           // 删除孤立对象、标记损坏的版本并修正引用计数，否则只报告
+	VerifyHash      bool `json:"verify_hash"`      // 读取对象内容校验哈希，需要完整读取每个对象
+}
+
+// TrashConfig 项目回收站配置
+type TrashConfig struct {
+	RetentionHours int `json:"retention_hours"` // 删除的项目在回收站中保留的小时数，期间可以恢复，0 表示立即清理
+}
+
+// CredentialConfig 凭证令牌生成配置
+type CredentialConfig struct {
+	TokenLength   int    `json:"token_length"`   // 令牌长度，不少于 16
+	TokenAlphabet string `json:"token_alphabet"` // 令牌字符集，至少 2 个不重复的可打印 ASCII 字符
 }
 
 type AdminConfig struct {
@@ -48,8 +111,11 @@ func Load() *Config {
 func loadFromFile() *Config {
 	config := &Config{
 		Server: ServerConfig{
-			Port: "8080",
-			Host: "localhost",
+			Port:         "8080",
+			Host:         "localhost",
+			ReadTimeout:  600,
+			WriteTimeout: 600,
+			IdleTimeout:  60,
 		},
 		OSS: OSSConfig{
 			Endpoint:        "",
@@ -57,6 +123,30 @@ func loadFromFile() *Config {
 			AccessKeySecret: "",
 			BucketName:      "",
 		},
+		Storage: StorageConfig{
+			Driver:            "",
+			LocalDir:          "./data/blobs",
+			SignExpireSeconds: 300,
+		},
+		Upload: UploadConfig{
+			MaxSizeMB:       1024,
+			MemoryMB:        32,
+			IntegrityCheck:  "quick",
+			SessionTTLHours: 24,
+		},
+		Retention: RetentionConfig{
+			IntervalMinutes: 60,
+		},
+		Reconcile: ReconcileConfig{
+			IntervalMinutes: 1440,
+		},
+		Trash: TrashConfig{
+			RetentionHours: 168,
+		},
+		Credential: CredentialConfig{
+			TokenLength:   32,
+			TokenAlphabet: "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789",
+		},
 		Admin: AdminConfig{
 			Username: "admin",
 			Password: "admin123",
@@ -91,6 +181,24 @@ func overrideWithEnv(config *Config) {
 	if value := os.Getenv("HOST"); value != "" {
 		config.Server.Host = value
 	}
+	if value := os.Getenv("SERVER_READ_TIMEOUT"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			config.Server.ReadTimeout = seconds
+		}
+	}
+	if value := os.Getenv("SERVER_WRITE_TIMEOUT"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			config.Server.WriteTimeout = seconds
+		}
+	}
+	if value := os.Getenv("SERVER_IDLE_TIMEOUT"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			config.Server.IdleTimeout = seconds
+		}
+	}
+	if value := os.Getenv("SERVER_TRUSTED_PROXIES"); value != "" {
+		config.Server.TrustedProxies = strings.Split(value, ",")
+	}
 	// OSS 配置
 	if value := os.Getenv("OSS_ENDPOINT"); value != "" {
 		config.OSS.Endpoint = value
@@ -104,6 +212,98 @@ func overrideWithEnv(config *Config) {
 	if value := os.Getenv("OSS_BUCKET_NAME"); value != "" {
 		config.OSS.BucketName = value
 	}
+	if value := os.Getenv("OSS_REGION"); value != "" {
+		config.OSS.Region = value
+	}
+	if value := os.Getenv("OSS_PATH_STYLE"); value != "" {
+		if pathStyle, err := strconv.ParseBool(value); err == nil {
+			config.OSS.PathStyle = pathStyle
+		}
+	}
+	// 存储配置
+	if value := os.Getenv("STORAGE_DRIVER"); value != "" {
+		config.Storage.Driver = value
+	}
+	if value := os.Getenv("STORAGE_LOCAL_DIR"); value != "" {
+		config.Storage.LocalDir = value
+	}
+	if value := os.Getenv("STORAGE_SIGN_EXPIRE_SECONDS"); value != "" {
+		if expireSeconds, err := strconv.Atoi(value); err == nil {
+			config.Storage.SignExpireSeconds = expireSeconds
+		}
+	}
+	if value := os.Getenv("STORAGE_COMPRESSION"); value != "" {
+		config.Storage.Compression = value
+	}
+	// 上传配置
+	if value := os.Getenv("UPLOAD_MAX_SIZE_MB"); value != "" {
+		if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+			config.Upload.MaxSizeMB = size
+		}
+	}
+	if value := os.Getenv("UPLOAD_MEMORY_MB"); value != "" {
+		if size, err := strconv.ParseInt(value, 10, 64); err == nil {
+			config.Upload.MemoryMB = size
+		}
+	}
+	if value := os.Getenv("UPLOAD_INTEGRITY_CHECK"); value != "" {
+		config.Upload.IntegrityCheck = value
+	}
+	if value := os.Getenv("UPLOAD_SESSION_TTL_HOURS"); value != "" {
+		if hours, err := strconv.Atoi(value); err == nil {
+			config.Upload.SessionTTLHours = hours
+		}
+	}
+	// 加密配置，ENCRYPTION_MASTER_KEYS 格式为 id1:base64,id2:base64
+	if value := os.Getenv("ENCRYPTION_MASTER_KEYS"); value != "" {
+		config.Encryption.MasterKeys = make(map[string]string)
+		for _, item := range strings.Split(value, ",") {
+			id, key, ok := strings.Cut(strings.TrimSpace(item), ":")
+			if ok {
+				config.Encryption.MasterKeys[id] = key
+			}
+		}
+	}
+	if value := os.Getenv("ENCRYPTION_ACTIVE_KEY"); value != "" {
+		config.Encryption.ActiveKey = value
+	}
+	// 保留策略配置
+	if value := os.Getenv("RETENTION_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := strconv.Atoi(value); err == nil {
+			config.Retention.IntervalMinutes = minutes
+		}
+	}
+	// 存储一致性检查配置
+	if value := os.Getenv("RECONCILE_INTERVAL_MINUTES"); value != "" {
+		if minutes, err := strconv.Atoi(value); err == nil {
+			config.Reconcile.IntervalMinutes = minutes
+		}
+	}
+	if value := os.Getenv("RECONCILE_REPAIR"); value != "" {
+		if repair, err := strconv.ParseBool(value); err == nil {
+			config.Reconcile.Repair = repair
+		}
+	}
+	if value := os.Getenv("RECONCILE_VERIFY_HASH"); value != "" {
+		if verify, err := strconv.ParseBool(value); err == nil {
+			config.Reconcile.VerifyHash = verify
+		}
+	}
+	// 回收站配置
+	if value := os.Getenv("TRASH_RETENTION_HOURS"); value != "" {
+		if hours, err := strconv.Atoi(value); err == nil {
+			config.Trash.RetentionHours = hours
+		}
+	}
+	// 凭证配置
+	if value := os.Getenv("CREDENTIAL_TOKEN_LENGTH"); value != "" {
+		if length, err := strconv.Atoi(value); err == nil {
+			config.Credential.TokenLength = length
+		}
+	}
+	if value := os.Getenv("CREDENTIAL_TOKEN_ALPHABET"); value != "" {
+		config.Credential.TokenAlphabet = value
+	}
 	// 管理员配置
 	if value := os.Getenv("ADMIN_USERNAME"); value != "" {
 		config.Admin.Username = value
@@ -0,0 +1,105 @@
+// Package compress 实现数据库版本在存储后端的压缩与解压。
+package compress
+
+import (
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// 压缩算法，取值同时用作 HTTP 的 Content-Encoding
+const (
+	None = ""
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
+// Normalize 规范化配置中的压缩算法，不支持的算法返回错误
+func Normalize(algorithm string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(algorithm)) {
+	case "", "none", "off":
+		return None, nil
+	case Gzip:
+		return Gzip, nil
+	case Zstd:
+		return Zstd, nil
+	default:
+		return "", fmt.Errorf("unsupported compression %q", algorithm)
+	}
+}
+
+// Compress 将 src 按指定算法压缩后写入 dst
+func Compress(dst io.Writer, src io.Reader, algorithm string) error {
+	var w io.WriteCloser
+	switch algorithm {
+	case Gzip:
+		w = gzip.NewWriter(dst)
+	case Zstd:
+		encoder, err := zstd.NewWriter(dst)
+		if err != nil {
+			return err
+		}
+		w = encoder
+	default:
+		return fmt.Errorf("unsupported compression %q", algorithm)
+	}
+
+	if _, err := io.Copy(w, src); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// NewReader 返回解压 r 的读取器，algorithm 为空时原样返回。
+// 关闭返回的读取器时会同时关闭 r。
+func NewReader(r io.ReadCloser, algorithm string) (io.ReadCloser, error) {
+	switch algorithm {
+	case None:
+		return r, nil
+	case Gzip:
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return &reader{Reader: gr, closers: []io.Closer{gr, r}}, nil
+	case Zstd:
+		decoder, err := zstd.NewReader(r)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		return &reader{Reader: decoder, closers: []io.Closer{zstdCloser{decoder}, r}}, nil
+	default:
+		r.Close()
+		return nil, fmt.Errorf("unsupported compression %q", algorithm)
+	}
+}
+
+type reader struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (r *reader) Close() error {
+	var errs []error
+	for _, c := range r.closers {
+		errs = append(errs, c.Close())
+	}
+	return errors.Join(errs...)
+}
+
+// zstdCloser zstd.Decoder 的 Close 没有返回值
+type zstdCloser struct {
+	decoder *zstd.Decoder
+}
+
+func (c zstdCloser) Close() error {
+	c.decoder.Close()
+	return nil
+}
@@ -2,10 +2,13 @@ package controller
 
 import (
 	"encoding/json"
-	"fmt"
-	"io"
+	"errors"
+	"log"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"chchma.com/cloudlite-sync/internal/models"
 	"chchma.com/cloudlite-sync/internal/utils"
@@ -19,16 +22,22 @@ func (h *Handler) ApiUploadDatabase(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 解析multipart表单
-	err := r.ParseMultipartForm(32 << 20) // 32MB
+	// 流式读取表单，在读取文件内容之前验证凭证，令牌需放在查询参数或文件之前的字段中
+	file, err := h.readUploadFields(w, r, "database")
 	if err != nil {
+		if isTooLarge(err) || errors.Is(err, errUploadFieldsTooLarge) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
+	if file != nil {
+		defer file.Close()
+	}
 
 	token := r.FormValue("token")
 	description := r.FormValue("description")
-
 	if token == "" {
 		http.Error(w, "Token is required", http.StatusBadRequest)
 		return
@@ -44,29 +53,37 @@ func (h *Handler) ApiUploadDatabase(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	if !h.checkCredential(w, r, credential, models.ScopeWrite) {
+		return
+	}
 
-	// 获取上传的文件
-	file, header, err := r.FormFile("database")
+	meta, err := parseVersionMeta(r)
 	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if file == nil {
 		http.Error(w, "Failed to get uploaded file", http.StatusBadRequest)
 		return
 	}
-	defer file.Close()
 
-	// 读取文件内容
-	fileData, err := io.ReadAll(file)
-	if err != nil {
-		http.Error(w, "Failed to read file", http.StatusInternalServerError)
+	// 流式写入存储并创建版本记录
+	dbVersion, existingVersion, err := h.storeVersion(credential.ProjectID, file.FileName(), description, meta, file)
+	if isTooLarge(err) {
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	if validationErr := validationError(err); validationErr != nil {
+		writeValidationError(w, validationErr)
+		return
+	}
+	if isLabelConflict(err) {
+		h.writeLabelConflict(w, credential.ProjectID, meta.Label)
 		return
 	}
-
-	// 生成文件哈希
-	fileHash := utils.GenerateHash(fileData)
-
-	// 检查文件是否已存在
-	existingVersion, err := h.db.GetVersionByHash(credential.ProjectID, fileHash)
 	if err != nil {
-		http.Error(w, "Failed to check existing version", http.StatusInternalServerError)
+		log.Printf("Failed to store database version: %v", err)
+		http.Error(w, "Failed to store database file", http.StatusInternalServerError)
 		return
 	}
 	if existingVersion != nil {
@@ -80,38 +97,6 @@ func (h *Handler) ApiUploadDatabase(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 生成版本信息
-	version := utils.GenerateVersion()
-	ossKey := utils.GenerateOSSKey(credential.ProjectID, version, header.Filename)
-
-	// 上传到OSS
-	err = h.ossClient.UploadFile(ossKey, fileData)
-	if err != nil {
-		http.Error(w, "Failed to upload file to OSS", http.StatusInternalServerError)
-		return
-	}
-
-	// 创建数据库版本记录
-	dbVersion := &models.DatabaseVersion{
-		ID:          utils.GenerateUUID(),
-		ProjectID:   credential.ProjectID,
-		Version:     version,
-		FileHash:    fileHash,
-		FileName:    header.Filename,
-		FileSize:    header.Size,
-		OSSKey:      ossKey,
-		Description: description,
-		IsLatest:    true, // 新上传的版本设为最新
-	}
-
-	err = h.db.CreateDatabaseVersion(dbVersion)
-	if err != nil {
-		// 如果数据库操作失败，删除已上传的文件
-		h.ossClient.DeleteFile(ossKey)
-		http.Error(w, "Failed to save version record", http.StatusInternalServerError)
-		return
-	}
-
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
 		"success": true,
@@ -145,6 +130,9 @@ func (h *Handler) ApiDownloadDatabase(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	if !h.checkCredential(w, r, credential, models.ScopeRead) {
+		return
+	}
 
 	var dbVersion *models.DatabaseVersion
 
@@ -177,20 +165,7 @@ func (h *Handler) ApiDownloadDatabase(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	// 从OSS下载文件
-	fileData, err := h.ossClient.DownloadFile(dbVersion.OSSKey)
-	if err != nil {
-		http.Error(w, "Failed to download file from OSS", http.StatusInternalServerError)
-		return
-	}
-
-	// 设置响应头
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", dbVersion.FileName))
-	w.Header().Set("Content-Length", strconv.FormatInt(dbVersion.FileSize, 10))
-
-	// 写入文件内容
-	w.Write(fileData)
+	h.deliverVersion(w, r, dbVersion)
 }
 
 // ApiListVersions 获取版本列表
@@ -225,13 +200,32 @@ func (h *Handler) ApiListVersions(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	if !h.checkCredential(w, r, credential, models.ScopeList) {
+		return
+	}
 
-	// 获取版本列表
-	versions, total, err := h.db.ListDatabaseVersions(credential.ProjectID, page, pageSize)
+	// 获取版本列表，提供 meta.<键名> 参数时只返回元数据匹配的版本
+	filter := make(map[string]string)
+	for field, values := range r.URL.Query() {
+		if key, ok := strings.CutPrefix(field, metadataFieldPrefix); ok && len(values) > 0 {
+			filter[key] = values[0]
+		}
+	}
+	var versions []*models.DatabaseVersion
+	var total int
+	if len(filter) > 0 {
+		versions, total, err = h.db.ListVersionsByMetadata(credential.ProjectID, filter, page, pageSize)
+	} else {
+		versions, total, err = h.db.ListDatabaseVersions(credential.ProjectID, page, pageSize)
+	}
 	if err != nil {
 		http.Error(w, "Failed to get versions", http.StatusInternalServerError)
 		return
 	}
+	if err := h.db.LoadVersionMetadata(versions...); err != nil {
+		http.Error(w, "Failed to get version metadata", http.StatusInternalServerError)
+		return
+	}
 
 	response := models.PaginatedResponse{
 		Data: versions,
@@ -272,10 +266,22 @@ func (h *Handler) ApiGetVersionInfo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid token", http.StatusUnauthorized)
 		return
 	}
+	if !h.checkCredential(w, r, credential, models.ScopeRead) {
+		return
+	}
+
+	minSchema, maxSchema, ranged, err := parseSchemaRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	var dbVersion *models.DatabaseVersion
 
-	if hash == "latest" {
+	if hash == "latest" && ranged {
+		// 获取客户端 schema 范围内的最新版本信息
+		dbVersion, err = h.db.GetLatestCompatibleVersion(credential.ProjectID, minSchema, maxSchema)
+	} else if hash == "latest" {
 		// 获取最新版本信息
 		dbVersion, err = h.db.GetLatestVersion(credential.ProjectID)
 	} else {
@@ -291,6 +297,10 @@ func (h *Handler) ApiGetVersionInfo(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Version not found", http.StatusNotFound)
 		return
 	}
+	if err := h.db.LoadVersionMetadata(dbVersion); err != nil {
+		http.Error(w, "Failed to get version metadata", http.StatusInternalServerError)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]interface{}{
@@ -351,26 +361,60 @@ func (h *Handler) ApiDownloadLatest(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid token or project", http.StatusUnauthorized)
 		return
 	}
-	// 获取最新版本
-	dbVersion, err := h.db.GetLatestVersion(projectID)
+	if !h.checkCredential(w, r, credential, models.ScopeRead) {
+		return
+	}
+	minSchema, maxSchema, ranged, err := parseSchemaRange(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	// 获取最新版本，客户端提供 schema 范围时返回范围内最新的版本
+	var dbVersion *models.DatabaseVersion
+	if ranged {
+		dbVersion, err = h.db.GetLatestCompatibleVersion(projectID, minSchema, maxSchema)
+	} else {
+		dbVersion, err = h.db.GetLatestVersion(projectID)
+	}
 	if err != nil {
 		http.Error(w, "Failed to get latest version", http.StatusInternalServerError)
 		return
 	}
 	if dbVersion == nil {
+		if ranged {
+			http.Error(w, "No database version compatible with the requested schema range", http.StatusNotFound)
+			return
+		}
 		http.Error(w, "No database version found", http.StatusNotFound)
 		return
 	}
-	// 下载文件
-	fileData, err := h.ossClient.DownloadFile(dbVersion.OSSKey)
-	if err != nil {
-		http.Error(w, "Failed to download file from OSS", http.StatusInternalServerError)
-		return
+	h.deliverVersion(w, r, dbVersion)
+}
+
+// parseSchemaRange 解析客户端支持的 schema 版本范围 schema_min、schema_max，两者都未提供时 ranged 为 false。
+// 只提供 schema_min 时不限上限，只提供 schema_max 时下限为 0
+func parseSchemaRange(r *http.Request) (minSchema, maxSchema int, ranged bool, err error) {
+	minValue := r.URL.Query().Get("schema_min")
+	maxValue := r.URL.Query().Get("schema_max")
+	if minValue == "" && maxValue == "" {
+		return 0, 0, false, nil
 	}
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", dbVersion.FileName))
-	w.Header().Set("Content-Length", strconv.FormatInt(dbVersion.FileSize, 10))
-	w.Write(fileData)
+
+	maxSchema = -1
+	if minValue != "" {
+		if minSchema, err = strconv.Atoi(minValue); err != nil || minSchema < 0 {
+			return 0, 0, false, errors.New("schema_min must be a non-negative integer")
+		}
+	}
+	if maxValue != "" {
+		if maxSchema, err = strconv.Atoi(maxValue); err != nil || maxSchema < 0 {
+			return 0, 0, false, errors.New("schema_max must be a non-negative integer")
+		}
+		if maxSchema < minSchema {
+			return 0, 0, false, errors.New("schema_max must not be less than schema_min")
+		}
+	}
+	return minSchema, maxSchema, true, nil
 }
 
 // 下载指定 hash 版本
@@ -396,6 +440,9 @@ func (h *Handler) ApiDownloadByHash(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid token or project", http.StatusUnauthorized)
 		return
 	}
+	if !h.checkCredential(w, r, credential, models.ScopeRead) {
+		return
+	}
 	// 获取指定 hash 版本
 	dbVersion, err := h.db.GetVersionByHash(projectID, hash)
 	if err != nil {
@@ -406,14 +453,91 @@ func (h *Handler) ApiDownloadByHash(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Version not found", http.StatusNotFound)
 		return
 	}
-	// 下载文件
-	fileData, err := h.ossClient.DownloadFile(dbVersion.OSSKey)
+	h.deliverVersion(w, r, dbVersion)
+}
+
+// authorizeAPI 校验 token 对应的凭证有效、属于该项目并通过 checkCredential 的检查，失败时写入错误响应并返回 nil
+func (h *Handler) authorizeAPI(w http.ResponseWriter, r *http.Request, token, projectID, scope string) *models.Credential {
+	if token == "" {
+		http.Error(w, "Token is required", http.StatusBadRequest)
+		return nil
+	}
+	credential, err := h.db.GetCredentialByToken(token)
 	if err != nil {
-		http.Error(w, "Failed to download file from OSS", http.StatusInternalServerError)
-		return
+		http.Error(w, "Failed to validate token", http.StatusInternalServerError)
+		return nil
 	}
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", dbVersion.FileName))
-	w.Header().Set("Content-Length", strconv.FormatInt(dbVersion.FileSize, 10))
-	w.Write(fileData)
+	if credential == nil || credential.ProjectID != projectID {
+		http.Error(w, "Invalid token or project", http.StatusUnauthorized)
+		return nil
+	}
+	if !h.checkCredential(w, r, credential, scope) {
+		return nil
+	}
+	return credential
+}
+
+// checkCredential 检查凭证未过期、客户端地址在允许范围内且具有 scope 权限，通过后记录最近使用时间和地址；
+// 不通过时写入错误响应并返回 false
+func (h *Handler) checkCredential(w http.ResponseWriter, r *http.Request, credential *models.Credential, scope string) bool {
+	if credential.Expired(time.Now()) {
+		http.Error(w, "Credential has expired", http.StatusUnauthorized)
+		return false
+	}
+	ip := h.clientIP(r)
+	if credential.AllowedCIDRs != "" {
+		allowed, err := utils.ParseCIDRs(utils.SplitList(credential.AllowedCIDRs))
+		if err != nil {
+			log.Printf("Invalid allowed CIDRs of credential %s: %v", credential.ID, err)
+		}
+		if err != nil || ip == nil || !utils.ContainsIP(allowed, ip) {
+			http.Error(w, "Client address is not allowed for this credential", http.StatusForbidden)
+			return false
+		}
+	}
+	if !credential.HasScope(scope) {
+		http.Error(w, "Credential does not have the "+scope+" scope", http.StatusForbidden)
+		return false
+	}
+
+	var address string
+	if ip != nil {
+		address = ip.String()
+	}
+	if err := h.db.TouchCredential(credential.ID, address); err != nil {
+		log.Printf("Failed to record credential usage: %v", err)
+	}
+	return true
+}
+
+// clientIP 返回客户端地址。请求来自可信代理时，从 X-Forwarded-For 末尾向前取第一个不属于可信代理的地址
+func (h *Handler) clientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !utils.ContainsIP(h.trustedProxies, ip) {
+		return ip
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		hop := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if hop == nil {
+			break
+		}
+		ip = hop
+		if !utils.ContainsIP(h.trustedProxies, hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// writeJSON 以指定状态码写入JSON响应
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
 }
@@ -0,0 +1,237 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+
+	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/session"
+	"github.com/go-chi/chi/v5"
+)
+
+// channelNamePattern 渠道名称：小写字母或数字开头，最长 32 个字符
+var channelNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]{0,31}$`)
+
+// validChannelName 校验渠道名称，latest 保留给最新版本指针
+func validChannelName(name string) bool {
+	return channelNamePattern.MatchString(name) && name != models.LatestChannel
+}
+
+// channelHistoryLimit 返回的渠道变更记录条数
+const channelHistoryLimit = 50
+
+// credentialActor 通过 API 操作时记录的操作者
+func credentialActor(credential *models.Credential) string {
+	return "credential:" + credential.ID
+}
+
+// loadChannels 获取项目的渠道及其指向的版本
+func (h *Handler) loadChannels(projectID string) ([]*models.Channel, error) {
+	channels, err := h.db.ListChannels(projectID)
+	if err != nil {
+		return nil, err
+	}
+	for _, channel := range channels {
+		channel.Version, err = h.db.GetDatabaseVersion(channel.VersionID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return channels, nil
+}
+
+// ApiDownloadChannel 下载渠道当前指向的版本
+func (h *Handler) ApiDownloadChannel(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.URL.Query().Get("token"), projectID, models.ScopeRead)
+	if credential == nil {
+		return
+	}
+
+	channel, err := h.db.GetChannel(projectID, chi.URLParam(r, "name"))
+	if err != nil {
+		http.Error(w, "Failed to get channel", http.StatusInternalServerError)
+		return
+	}
+	if channel == nil {
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	}
+	dbVersion, err := h.db.GetDatabaseVersion(channel.VersionID)
+	if err != nil {
+		http.Error(w, "Failed to get version", http.StatusInternalServerError)
+		return
+	}
+	if dbVersion == nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	h.deliverVersion(w, r, dbVersion)
+}
+
+// ApiListChannels 列出项目的渠道和最近的变更记录
+func (h *Handler) ApiListChannels(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.URL.Query().Get("token"), projectID, models.ScopeList)
+	if credential == nil {
+		return
+	}
+
+	channels, err := h.loadChannels(projectID)
+	if err != nil {
+		http.Error(w, "Failed to list channels", http.StatusInternalServerError)
+		return
+	}
+	history, err := h.db.ListChannelMoves(projectID, r.URL.Query().Get("channel"), channelHistoryLimit)
+	if err != nil {
+		http.Error(w, "Failed to list channel history", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success":  true,
+		"channels": channels,
+		"history":  history,
+	})
+}
+
+// ApiSetChannel 将渠道指向指定哈希的版本，渠道不存在时创建
+func (h *Handler) ApiSetChannel(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.FormValue("token"), projectID, models.ScopePromote)
+	if credential == nil {
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+	if !validChannelName(name) {
+		http.Error(w, "Invalid channel name", http.StatusBadRequest)
+		return
+	}
+	hash := r.FormValue("hash")
+	if hash == "" {
+		http.Error(w, "Parameter hash is required", http.StatusBadRequest)
+		return
+	}
+	dbVersion, err := h.db.GetVersionByHash(projectID, hash)
+	if err != nil {
+		http.Error(w, "Failed to get version", http.StatusInternalServerError)
+		return
+	}
+	if dbVersion == nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	move, err := h.db.SetChannel(projectID, name, dbVersion.ID, credentialActor(credential))
+	if err != nil {
+		log.Printf("Failed to set channel %s: %v", name, err)
+		http.Error(w, "Failed to set channel", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"move":    move,
+		"version": dbVersion,
+	})
+}
+
+// ApiRollbackChannel 将渠道回滚到上一次变更之前的版本
+func (h *Handler) ApiRollbackChannel(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.FormValue("token"), projectID, models.ScopePromote)
+	if credential == nil {
+		return
+	}
+
+	move, err := h.db.RollbackChannel(projectID, chi.URLParam(r, "name"), credentialActor(credential))
+	switch {
+	case errors.Is(err, database.ErrChannelNotFound):
+		http.Error(w, "Channel not found", http.StatusNotFound)
+		return
+	case errors.Is(err, database.ErrNoPreviousVersion):
+		http.Error(w, "No previous version to roll back to", http.StatusConflict)
+		return
+	case err != nil:
+		log.Printf("Failed to roll back channel: %v", err)
+		http.Error(w, "Failed to roll back channel", http.StatusInternalServerError)
+		return
+	}
+	dbVersion, err := h.db.GetDatabaseVersion(move.ToVersionID)
+	if err != nil {
+		http.Error(w, "Failed to get version", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"move":    move,
+		"version": dbVersion,
+	})
+}
+
+// SetProjectChannel 网页端创建渠道或将渠道指向其他版本
+func (h *Handler) SetProjectChannel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := r.FormValue("project_id")
+	name := r.FormValue("name")
+	if !validChannelName(name) {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error="+url.QueryEscape("渠道名称只能包含小写字母、数字、点、下划线和短横线，且不能为 latest"), http.StatusSeeOther)
+		return
+	}
+	dbVersion, err := h.db.GetVersionByHash(projectID, r.FormValue("hash"))
+	if err != nil || dbVersion == nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=版本不存在", http.StatusSeeOther)
+		return
+	}
+	if _, err := h.db.SetChannel(projectID, name, dbVersion.ID, session.GetUsername(r)); err != nil {
+		log.Printf("Failed to set channel %s: %v", name, err)
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=更新渠道失败", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
+
+// RollbackProjectChannel 网页端回滚渠道
+func (h *Handler) RollbackProjectChannel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := r.FormValue("project_id")
+	_, err := h.db.RollbackChannel(projectID, r.FormValue("name"), session.GetUsername(r))
+	switch {
+	case errors.Is(err, database.ErrChannelNotFound):
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=渠道不存在", http.StatusSeeOther)
+		return
+	case errors.Is(err, database.ErrNoPreviousVersion):
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=没有可回滚的版本", http.StatusSeeOther)
+		return
+	case err != nil:
+		log.Printf("Failed to roll back channel: %v", err)
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=回滚渠道失败", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
+
+// DeleteProjectChannel 网页端删除渠道
+func (h *Handler) DeleteProjectChannel(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := r.FormValue("project_id")
+	if err := h.db.DeleteChannel(projectID, r.FormValue("name")); err != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=删除渠道失败", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
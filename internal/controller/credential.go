@@ -2,14 +2,29 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
-	"chchma.com/cloudlite-sync/internal/database"
 	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/session"
 	"chchma.com/cloudlite-sync/internal/utils"
 )
 
+// newTokenFlash 新建凭证后一次性展示完整令牌所用的消息键
+const newTokenFlash = "new_token"
+
+// createCredential 生成令牌并写入凭证，令牌与已有凭证重复时重新生成
+func (h *Handler) createCredential(credential *models.Credential) error {
+	return h.tokens.Insert(func(token string) error {
+		credential.Token = token
+		return h.db.CreateCredential(credential)
+	})
+}
+
 // CreateCredential 创建凭证
 func (h *Handler) CreateCredential(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -34,20 +49,36 @@ func (h *Handler) CreateCredential(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	scopes, err := parseScopes(r.Form["scopes"])
+	if err != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error="+url.QueryEscape("凭证权限无效："+err.Error()), http.StatusSeeOther)
+		return
+	}
+
 	// 创建凭证
 	credential := &models.Credential{
 		ID:        utils.GenerateUUID(),
 		ProjectID: projectID,
-		Token:     database.GenerateToken(),
 		IsActive:  true,
+		Scopes:    scopes,
+	}
+	if err := parseCredentialSettings(r, credential); err != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error="+url.QueryEscape("凭证设置无效："+err.Error()), http.StatusSeeOther)
+		return
 	}
 
-	err = h.db.CreateCredential(credential)
+	err = h.createCredential(credential)
 	if err != nil {
 		http.Error(w, "Failed to create credential", http.StatusInternalServerError)
 		return
 	}
 
+	// 数据库中只保存哈希，完整令牌暂存在服务端（会话中只有随机ID），在项目详情页展示一次
+	if err := session.AddSecretFlash(w, r, newTokenFlash, credential.Token); err != nil {
+		http.Error(w, "Failed to save session", http.StatusInternalServerError)
+		return
+	}
+
 	// 重定向回项目详情页面
 	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
 }
@@ -139,6 +170,134 @@ func (h *Handler) ActivateCredential(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
 }
 
+// UpdateCredentialScopes 修改凭证的权限
+func (h *Handler) UpdateCredentialScopes(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	credentialID := r.FormValue("id")
+	projectID := r.FormValue("project_id")
+
+	credential, err := h.db.GetCredential(credentialID)
+	if err != nil {
+		http.Error(w, "Failed to get credential", http.StatusInternalServerError)
+		return
+	}
+	if credential == nil || credential.ProjectID != projectID {
+		http.Error(w, "Credential not found", http.StatusNotFound)
+		return
+	}
+
+	scopes, err := parseScopes(r.Form["scopes"])
+	if err != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error="+url.QueryEscape("凭证权限无效："+err.Error()), http.StatusSeeOther)
+		return
+	}
+	credential.Scopes = scopes
+	if err := h.db.UpdateCredential(credential); err != nil {
+		http.Error(w, "Failed to update credential", http.StatusInternalServerError)
+		return
+	}
+
+	// 重定向回项目详情页面
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
+
+// UpdateCredential 修改凭证的名称、描述、过期时间和允许的地址段
+func (h *Handler) UpdateCredential(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	credentialID := r.FormValue("id")
+	projectID := r.FormValue("project_id")
+
+	credential, err := h.db.GetCredential(credentialID)
+	if err != nil {
+		http.Error(w, "Failed to get credential", http.StatusInternalServerError)
+		return
+	}
+	if credential == nil || credential.ProjectID != projectID {
+		http.Error(w, "Credential not found", http.StatusNotFound)
+		return
+	}
+
+	if err := parseCredentialSettings(r, credential); err != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error="+url.QueryEscape("凭证设置无效："+err.Error()), http.StatusSeeOther)
+		return
+	}
+	if err := h.db.UpdateCredential(credential); err != nil {
+		http.Error(w, "Failed to update credential", http.StatusInternalServerError)
+		return
+	}
+
+	// 重定向回项目详情页面
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
+
+// credentialTimeLayout 网页表单中 datetime-local 输入框的时间格式，浏览器提交的是不带时区的本地时间，按服务器时区解析
+const credentialTimeLayout = "2006-01-02T15:04"
+
+// parseCredentialSettings 从表单读取 name、description、expires_at（为空表示永不过期）和 allowed_cidrs（逗号或换行分隔，为空表示不限制）
+func parseCredentialSettings(r *http.Request, credential *models.Credential) error {
+	name := strings.TrimSpace(r.FormValue("name"))
+	if len(name) > 64 {
+		return errors.New("name is too long (max 64)")
+	}
+	credential.Name = name
+	credential.Description = strings.TrimSpace(r.FormValue("description"))
+
+	credential.ExpiresAt = nil
+	if value := strings.TrimSpace(r.FormValue("expires_at")); value != "" {
+		expiresAt, err := time.ParseInLocation(credentialTimeLayout, value, time.Local)
+		if err != nil {
+			return errors.New("invalid expiry time")
+		}
+		credential.ExpiresAt = &expiresAt
+	}
+
+	networks, err := utils.ParseCIDRs(utils.SplitList(r.FormValue("allowed_cidrs")))
+	if err != nil {
+		return err
+	}
+	cidrs := make([]string, len(networks))
+	for i, network := range networks {
+		cidrs[i] = network.String()
+	}
+	credential.AllowedCIDRs = strings.Join(cidrs, ",")
+	return nil
+}
+
+// parseScopes 校验所选权限并按 models.CredentialScopes 的顺序拼接，至少需要一项
+func parseScopes(values []string) (string, error) {
+	selected := make(map[string]bool)
+	for _, value := range values {
+		for _, scope := range strings.Split(value, ",") {
+			if scope = strings.TrimSpace(scope); scope != "" {
+				selected[scope] = true
+			}
+		}
+	}
+
+	var scopes []string
+	for _, scope := range models.CredentialScopes {
+		if selected[scope] {
+			scopes = append(scopes, scope)
+			delete(selected, scope)
+		}
+	}
+	for scope := range selected {
+		return "", errors.New("unknown scope " + scope)
+	}
+	if len(scopes) == 0 {
+		return "", errors.New("at least one scope is required")
+	}
+	return strings.Join(scopes, ","), nil
+}
+
 // API获取凭证列表
 func (h *Handler) APIListCredentials(w http.ResponseWriter, r *http.Request) {
 	projectID := r.URL.Query().Get("project_id")
@@ -183,7 +342,8 @@ func (h *Handler) APICreateCredential(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var req struct {
-		ProjectID string `json:"project_id"`
+		ProjectID string   `json:"project_id"`
+		Scopes    []string `json:"scopes"` // 省略时授予全部权限
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
@@ -207,15 +367,24 @@ func (h *Handler) APICreateCredential(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if len(req.Scopes) == 0 {
+		req.Scopes = models.CredentialScopes
+	}
+	scopes, err := parseScopes(req.Scopes)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
 	// 创建凭证
 	credential := &models.Credential{
 		ID:        utils.GenerateUUID(),
 		ProjectID: req.ProjectID,
-		Token:     database.GenerateToken(),
 		IsActive:  true,
+		Scopes:    scopes,
 	}
 
-	err = h.db.CreateCredential(credential)
+	err = h.createCredential(credential)
 	if err != nil {
 		http.Error(w, "Failed to create credential", http.StatusInternalServerError)
 		return
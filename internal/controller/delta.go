@@ -0,0 +1,223 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"chchma.com/cloudlite-sync/internal/delta"
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// ApiDownloadDelta 下载从指定版本到最新版本的页级差量。
+// 基础版本不存在时返回404，客户端应回退为完整下载；已是最新版本时返回304。
+func (h *Handler) ApiDownloadDelta(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.URL.Query().Get("token"), projectID, models.ScopeRead)
+	if credential == nil {
+		return
+	}
+
+	fromHash := r.URL.Query().Get("from")
+	if fromHash == "" {
+		http.Error(w, "Parameter from is required", http.StatusBadRequest)
+		return
+	}
+
+	fromVersion, err := h.db.GetVersionByHash(projectID, fromHash)
+	if err != nil {
+		http.Error(w, "Failed to get base version", http.StatusInternalServerError)
+		return
+	}
+	if fromVersion == nil {
+		http.Error(w, "Base version not found", http.StatusNotFound)
+		return
+	}
+
+	toVersion, err := h.db.GetLatestVersion(projectID)
+	if err != nil {
+		http.Error(w, "Failed to get latest version", http.StatusInternalServerError)
+		return
+	}
+	if toVersion == nil {
+		http.Error(w, "No database version found", http.StatusNotFound)
+		return
+	}
+	if toVersion.ID == fromVersion.ID {
+		w.Header().Set("ETag", `"`+toVersion.FileHash+`"`)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	etag := deltaETag(fromVersion, toVersion)
+	if notModified(r, etag, toVersion.CreatedAt) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	versionDelta, err := h.db.GetVersionDelta(fromVersion.ID, toVersion.ID)
+	if err != nil {
+		http.Error(w, "Failed to get delta", http.StatusInternalServerError)
+		return
+	}
+	if versionDelta != nil && versionDelta.DeltaSize >= toVersion.FileSize {
+		h.sendFullInsteadOfDelta(w, r, toVersion)
+		return
+	}
+	if versionDelta != nil {
+		object, err := h.openStored(versionDelta.OSSKey, versionDelta.KeyID)
+		if err == nil {
+			defer object.Close()
+			setDeltaHeaders(w, fromVersion, toVersion, versionDelta)
+			if _, err := io.Copy(w, object); err != nil {
+				log.Printf("Failed to send delta %s: %v", versionDelta.OSSKey, err)
+			}
+			return
+		}
+		// 缓存对象丢失时重新生成
+		log.Printf("Cached delta %s unavailable, recomputing: %v", versionDelta.OSSKey, err)
+	}
+
+	tmp, versionDelta, err := h.computeDelta(fromVersion, toVersion)
+	if err != nil {
+		log.Printf("Failed to compute delta: %v", err)
+		http.Error(w, "Failed to compute delta", http.StatusInternalServerError)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if versionDelta.DeltaSize >= toVersion.FileSize {
+		h.sendFullInsteadOfDelta(w, r, toVersion)
+		return
+	}
+	setDeltaHeaders(w, fromVersion, toVersion, versionDelta)
+	if _, err := io.Copy(w, tmp); err != nil {
+		log.Printf("Failed to send delta: %v", err)
+	}
+}
+
+// computeDelta 生成差量到临时文件并缓存到存储后端，返回定位到开头的临时文件
+func (h *Handler) computeDelta(fromVersion, toVersion *models.DatabaseVersion) (*os.File, *models.VersionDelta, error) {
+	source, err := h.openVersion(fromVersion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open base version: %w", err)
+	}
+	defer source.Close()
+
+	target, err := h.openVersion(toVersion)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open target version: %w", err)
+	}
+	defer target.Close()
+
+	tmp, err := os.CreateTemp("", "cloudlite-delta-*")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	fail := func(err error) (*os.File, *models.VersionDelta, error) {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, nil, err
+	}
+
+	stats, err := delta.Compute(tmp, source, fromVersion.FileSize, target, toVersion.FileSize)
+	if err != nil {
+		return fail(err)
+	}
+	size, err := tmp.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return fail(err)
+	}
+
+	versionDelta := &models.VersionDelta{
+		ID:            utils.GenerateUUID(),
+		ProjectID:     toVersion.ProjectID,
+		FromVersionID: fromVersion.ID,
+		ToVersionID:   toVersion.ID,
+		PageSize:      stats.PageSize,
+		ChangedPages:  stats.ChangedPages,
+		DeltaSize:     size,
+		OSSKey:        utils.GenerateDeltaKey(toVersion.ProjectID, fromVersion.FileHash, toVersion.FileHash),
+	}
+
+	// 缓存失败不影响本次响应
+	if err := h.cacheDelta(versionDelta, tmp); err != nil {
+		log.Printf("Failed to cache delta %s: %v", versionDelta.OSSKey, err)
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return fail(err)
+	}
+	return tmp, versionDelta, nil
+}
+
+// cacheDelta 将差量写入存储后端并记录，启用加密时与版本一样用项目数据密钥加密
+func (h *Handler) cacheDelta(versionDelta *models.VersionDelta, tmp *os.File) error {
+	var object io.ReadSeeker = tmp
+	if h.keys.Enabled() {
+		keyID, dataKey, err := h.projectDataKey(versionDelta.ProjectID)
+		if err != nil {
+			return err
+		}
+		encrypted, _, err := encryptFile(tmp, dataKey)
+		if err != nil {
+			return err
+		}
+		defer encrypted.Close()
+		object, versionDelta.KeyID = encrypted, keyID
+	}
+	if err := h.putVerified(versionDelta.OSSKey, object); err != nil {
+		return err
+	}
+	return h.db.CreateVersionDelta(versionDelta)
+}
+
+// sendFullInsteadOfDelta 差量不比完整文件小时改为返回完整的目标版本，响应头 X-Delta-Fallback 为 full
+func (h *Handler) sendFullInsteadOfDelta(w http.ResponseWriter, r *http.Request, toVersion *models.DatabaseVersion) {
+	w.Header().Set("X-Delta-Fallback", "full")
+	w.Header().Set("X-Delta-To", toVersion.FileHash)
+	if err := h.sendVersionFile(w, r, toVersion); err != nil {
+		http.Error(w, "Failed to download file from storage", http.StatusInternalServerError)
+	}
+}
+
+// deltaETag 差量的 ETag，由起止版本的哈希组成，与完整文件的 ETag 区分
+func deltaETag(fromVersion, toVersion *models.DatabaseVersion) string {
+	return `"delta-` + fromVersion.FileHash + "-" + toVersion.FileHash + `"`
+}
+
+// setDeltaHeaders 设置差量响应头
+func setDeltaHeaders(w http.ResponseWriter, fromVersion, toVersion *models.DatabaseVersion, versionDelta *models.VersionDelta) {
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.delta", fromVersion.FileHash, toVersion.FileHash))
+	w.Header().Set("Content-Length", strconv.FormatInt(versionDelta.DeltaSize, 10))
+	w.Header().Set("ETag", deltaETag(fromVersion, toVersion))
+	w.Header().Set("X-Delta-From", fromVersion.FileHash)
+	w.Header().Set("X-Delta-To", toVersion.FileHash)
+	w.Header().Set("X-Delta-Changed-Pages", strconv.Itoa(versionDelta.ChangedPages))
+}
+
+// deleteVersionDeltas 删除与版本相关的差量缓存
+func (h *Handler) deleteVersionDeltas(versionID string) {
+	deltas, err := h.db.ListVersionDeltas(versionID)
+	if err != nil {
+		log.Printf("Failed to list deltas of version %s: %v", versionID, err)
+		return
+	}
+	for _, versionDelta := range deltas {
+		if err := h.storage.Delete(versionDelta.OSSKey); err != nil {
+			log.Printf("Failed to delete delta %s: %v", versionDelta.OSSKey, err)
+			continue
+		}
+		if err := h.db.DeleteVersionDelta(versionDelta.ID); err != nil {
+			log.Printf("Failed to delete delta record %s: %v", versionDelta.ID, err)
+		}
+	}
+}
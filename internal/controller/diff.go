@@ -0,0 +1,221 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+
+	"chchma.com/cloudlite-sync/internal/delta"
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/session"
+	"chchma.com/cloudlite-sync/internal/sqlitediff"
+	"chchma.com/cloudlite-sync/internal/template"
+	"github.com/go-chi/chi/v5"
+)
+
+// errInvalidDatabase 版本文件无法作为 SQLite 数据库打开
+var errInvalidDatabase = errors.New("not a valid SQLite database")
+
+// VersionDiff 两个版本之间的差异
+type VersionDiff struct {
+	From  *models.DatabaseVersion `json:"from"`
+	To    *models.DatabaseVersion `json:"to"`
+	Pages *delta.Stats            `json:"pages"`
+	// Changes 表结构、索引等对象和各表行数的变化
+	Changes *sqlitediff.Diff `json:"changes"`
+}
+
+// ApiVersionDiff 比较两个版本的表结构、行数和变化的页，to 省略时与最新版本比较
+func (h *Handler) ApiVersionDiff(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.URL.Query().Get("token"), projectID, models.ScopeRead)
+	if credential == nil {
+		return
+	}
+
+	fromHash := r.URL.Query().Get("from")
+	if fromHash == "" {
+		http.Error(w, "Parameter from is required", http.StatusBadRequest)
+		return
+	}
+	fromVersion, toVersion, err := h.loadDiffVersions(projectID, fromHash, r.URL.Query().Get("to"))
+	if err != nil {
+		http.Error(w, "Failed to get version", http.StatusInternalServerError)
+		return
+	}
+	if fromVersion == nil || toVersion == nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	diff, err := h.diffVersions(fromVersion, toVersion)
+	if err != nil {
+		if errors.Is(err, errInvalidDatabase) {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("Failed to diff versions: %v", err)
+		http.Error(w, "Failed to diff versions", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"diff":    diff,
+	})
+}
+
+// VersionDiffPage 网页端查看两个版本的差异
+func (h *Handler) VersionDiffPage(w http.ResponseWriter, r *http.Request) {
+	projectID := r.URL.Query().Get("project_id")
+	fromHash := r.URL.Query().Get("from")
+	toHash := r.URL.Query().Get("to")
+
+	project, err := h.db.GetProject(projectID)
+	if err != nil || project == nil {
+		http.Redirect(w, r, "/?error=项目不存在", http.StatusSeeOther)
+		return
+	}
+	versions, _, err := h.db.ListDatabaseVersions(projectID, 1, 100)
+	if err != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=获取版本失败", http.StatusSeeOther)
+		return
+	}
+
+	data := map[string]interface{}{
+		"project":  project,
+		"versions": versions,
+		"from":     fromHash,
+		"to":       toHash,
+	}
+	pageData := template.NewPageData("版本对比", data)
+	pageData.SetUser(session.GetUsername(r))
+
+	if fromHash != "" {
+		fromVersion, toVersion, err := h.loadDiffVersions(projectID, fromHash, toHash)
+		switch {
+		case err != nil:
+			pageData.SetError("获取版本失败")
+		case fromVersion == nil || toVersion == nil:
+			pageData.SetError("版本不存在")
+		default:
+			data["to"] = toVersion.FileHash
+			diff, err := h.diffVersions(fromVersion, toVersion)
+			if err != nil {
+				log.Printf("Failed to diff versions: %v", err)
+				if errors.Is(err, errInvalidDatabase) {
+					pageData.SetError("版本文件不是有效的 SQLite 数据库")
+				} else {
+					pageData.SetError("对比版本失败")
+				}
+			} else {
+				data["diff"] = diff
+			}
+		}
+	}
+
+	h.tmpl.Render(w, "version_diff.html", pageData)
+}
+
+// loadDiffVersions 按哈希获取对比的两个版本，toHash 为空时使用最新版本
+func (h *Handler) loadDiffVersions(projectID, fromHash, toHash string) (fromVersion, toVersion *models.DatabaseVersion, err error) {
+	fromVersion, err = h.db.GetVersionByHash(projectID, fromHash)
+	if err != nil {
+		return nil, nil, err
+	}
+	if toHash == "" {
+		toVersion, err = h.db.GetLatestVersion(projectID)
+	} else {
+		toVersion, err = h.db.GetVersionByHash(projectID, toHash)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return fromVersion, toVersion, nil
+}
+
+// diffVersions 将两个版本下载到临时文件后比较
+func (h *Handler) diffVersions(fromVersion, toVersion *models.DatabaseVersion) (*VersionDiff, error) {
+	fromPath, err := h.versionTempFile(fromVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(fromPath)
+	toPath, err := h.versionTempFile(toVersion)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(toPath)
+
+	fromSnapshot, err := sqlitediff.Inspect(fromPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: version %s: %v", errInvalidDatabase, fromVersion.FileHash, err)
+	}
+	toSnapshot, err := sqlitediff.Inspect(toPath)
+	if err != nil {
+		return nil, fmt.Errorf("%w: version %s: %v", errInvalidDatabase, toVersion.FileHash, err)
+	}
+
+	pages, err := comparePages(fromPath, toPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &VersionDiff{
+		From:    fromVersion,
+		To:      toVersion,
+		Pages:   pages,
+		Changes: sqlitediff.Compare(fromSnapshot, toSnapshot),
+	}, nil
+}
+
+// comparePages 统计两个文件之间变化的页
+func comparePages(fromPath, toPath string) (*delta.Stats, error) {
+	source, err := os.Open(fromPath)
+	if err != nil {
+		return nil, err
+	}
+	defer source.Close()
+	target, err := os.Open(toPath)
+	if err != nil {
+		return nil, err
+	}
+	defer target.Close()
+
+	sourceInfo, err := source.Stat()
+	if err != nil {
+		return nil, err
+	}
+	targetInfo, err := target.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return delta.Compute(io.Discard, source, sourceInfo.Size(), target, targetInfo.Size())
+}
+
+// versionTempFile 将版本内容写入临时文件，调用方负责删除
+func (h *Handler) versionTempFile(dbVersion *models.DatabaseVersion) (string, error) {
+	object, err := h.openVersion(dbVersion)
+	if err != nil {
+		return "", fmt.Errorf("failed to open version %s: %w", dbVersion.FileHash, err)
+	}
+	defer object.Close()
+
+	tmp, err := os.CreateTemp("", "cloudlite-version-*.db")
+	if err != nil {
+		return "", fmt.Errorf("failed to create temp file: %w", err)
+	}
+	if _, err := io.Copy(tmp, object); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return "", fmt.Errorf("failed to read version %s: %w", dbVersion.FileHash, err)
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
@@ -0,0 +1,213 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/compress"
+	"chchma.com/cloudlite-sync/internal/envelope"
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/storage"
+)
+
+// deliverVersion 按下载模式返回版本文件：由服务器中转内容、302 跳转到预签名地址，或返回包含预签名地址的JSON。
+// 请求参数 mode 可覆盖项目的默认下载模式；存储后端不支持预签名或版本已加密时回退为中转。
+// 响应头 X-Schema-Version 给出版本的 schema 版本。
+func (h *Handler) deliverVersion(w http.ResponseWriter, r *http.Request, dbVersion *models.DatabaseVersion) {
+	w.Header().Set("X-Schema-Version", strconv.Itoa(dbVersion.SchemaVersion))
+
+	mode := r.URL.Query().Get("mode")
+	explicit := mode != ""
+	if !explicit {
+		project, err := h.db.GetProject(dbVersion.ProjectID)
+		if err != nil {
+			http.Error(w, "Failed to get project", http.StatusInternalServerError)
+			return
+		}
+		if project != nil {
+			mode = project.DownloadMode
+		}
+	}
+
+	switch mode {
+	case models.DownloadModeRedirect, models.DownloadModeURL:
+		etag := `"` + dbVersion.FileHash + `"`
+		if notModified(r, etag, dbVersion.CreatedAt) {
+			w.Header().Set("ETag", etag)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		// 加密存储的对象只能由服务器解密后中转
+		if dbVersion.KeyID != "" {
+			if explicit && mode == models.DownloadModeURL {
+				http.Error(w, "Signed URL is not available for encrypted versions", http.StatusNotImplemented)
+				return
+			}
+			break
+		}
+
+		expires := time.Duration(h.config.Storage.SignExpireSeconds) * time.Second
+		signedURL, err := h.storage.SignURL(dbVersion.OSSKey, expires)
+		if errors.Is(err, storage.ErrNotSupported) {
+			if explicit && mode == models.DownloadModeURL {
+				http.Error(w, "Signed URL is not supported by storage backend", http.StatusNotImplemented)
+				return
+			}
+			break
+		}
+		if err != nil {
+			log.Printf("Failed to sign URL for %s: %v", dbVersion.OSSKey, err)
+			http.Error(w, "Failed to generate download URL", http.StatusInternalServerError)
+			return
+		}
+
+		if mode == models.DownloadModeRedirect {
+			// 存储对象是压缩后的内容且不带 Content-Encoding，跳转后客户端无法识别，改为中转
+			if dbVersion.Compression != "" {
+				break
+			}
+			w.Header().Set("ETag", etag)
+			http.Redirect(w, r, signedURL, http.StatusFound)
+			return
+		}
+		// 压缩存储的版本需要客户端按 compression 自行解压
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success":     true,
+			"url":         signedURL,
+			"expires_at":  time.Now().Add(expires),
+			"compression": dbVersion.Compression,
+			"version":     dbVersion,
+		})
+		return
+	case "", models.DownloadModeProxy:
+	default:
+		http.Error(w, "Invalid download mode", http.StatusBadRequest)
+		return
+	}
+
+	if err := h.sendVersionFile(w, r, dbVersion); err != nil {
+		http.Error(w, "Failed to download file from storage", http.StatusInternalServerError)
+	}
+}
+
+// sendVersionFile 从存储后端读取版本文件并写入响应。
+// ETag 为文件哈希，Last-Modified 为版本创建时间，支持 If-None-Match / If-Modified-Since 条件请求
+// 以及 Range / If-Range 断点续传。压缩存储的版本在客户端的 Accept-Encoding 支持该算法且
+// 不是区间请求时直接返回压缩内容，否则在服务端解压；加密存储的版本总是在服务端解密。
+func (h *Handler) sendVersionFile(w http.ResponseWriter, r *http.Request, dbVersion *models.DatabaseVersion) error {
+	etag := `"` + dbVersion.FileHash + `"`
+	encoded := dbVersion.Compression != "" && r.Header.Get("Range") == "" &&
+		acceptsEncoding(r, dbVersion.Compression)
+	if dbVersion.Compression != "" {
+		w.Header().Add("Vary", "Accept-Encoding")
+	}
+
+	// 内容未变化时直接返回304，无需访问存储后端。两种编码的 ETag 都视为命中
+	encodedETag := `"` + dbVersion.FileHash + "-" + dbVersion.Compression + `"`
+	if notModified(r, etag, dbVersion.CreatedAt) || (dbVersion.Compression != "" && notModified(r, encodedETag, dbVersion.CreatedAt)) {
+		if encoded {
+			etag = encodedETag
+		}
+		w.Header().Set("ETag", etag)
+		w.Header().Set("Last-Modified", dbVersion.CreatedAt.UTC().Format(http.TimeFormat))
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	var object io.ReadSeekCloser
+	var err error
+	switch {
+	case encoded:
+		etag = encodedETag
+		// ServeContent 在设置了 Content-Encoding 时不会写入长度
+		size := dbVersion.StoredSize
+		if dbVersion.KeyID != "" {
+			size = envelope.PlainSize(size)
+			object, err = storage.NewStreamSeeker(func() (io.ReadCloser, error) {
+				return h.openStored(dbVersion.OSSKey, dbVersion.KeyID)
+			}, size)
+		} else {
+			object, err = storage.OpenReadSeeker(h.storage, dbVersion.OSSKey, size)
+		}
+		w.Header().Set("Content-Encoding", dbVersion.Compression)
+		w.Header().Set("Content-Length", strconv.FormatInt(size, 10))
+	case dbVersion.Compression != "" || dbVersion.KeyID != "":
+		object, err = storage.NewStreamSeeker(func() (io.ReadCloser, error) {
+			return h.openVersion(dbVersion)
+		}, dbVersion.FileSize)
+	default:
+		object, err = storage.OpenReadSeeker(h.storage, dbVersion.OSSKey, dbVersion.FileSize)
+	}
+	if err != nil {
+		w.Header().Del("Content-Encoding")
+		w.Header().Del("Content-Length")
+		log.Printf("Failed to get object %s: %v", dbVersion.OSSKey, err)
+		return err
+	}
+	defer object.Close()
+
+	if !encoded {
+		setDigestHeaders(w, r, dbVersion)
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", dbVersion.FileName))
+	http.ServeContent(w, r, dbVersion.FileName, dbVersion.CreatedAt, object)
+	return nil
+}
+
+// acceptsEncoding 判断请求的 Accept-Encoding 是否接受指定编码（q=0 表示拒绝）
+func acceptsEncoding(r *http.Request, encoding string) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if !strings.EqualFold(strings.TrimSpace(name), encoding) {
+			continue
+		}
+		if q, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if value, err := strconv.ParseFloat(q, 64); err == nil && value == 0 {
+				return false
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// notModified 判断条件请求是否命中缓存，If-None-Match 优先于 If-Modified-Since
+func notModified(r *http.Request, etag string, modtime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		for _, tag := range strings.Split(inm, ",") {
+			tag = strings.TrimSpace(tag)
+			if tag == "*" || strings.TrimPrefix(tag, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+	if ims := r.Header.Get("If-Modified-Since"); ims != "" {
+		t, err := http.ParseTime(ims)
+		if err == nil && !modtime.Truncate(time.Second).After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// openVersion 打开版本文件的原始内容（已解密、解压），调用方负责关闭
+func (h *Handler) openVersion(dbVersion *models.DatabaseVersion) (io.ReadCloser, error) {
+	object, err := h.openStored(dbVersion.OSSKey, dbVersion.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	return compress.NewReader(object, dbVersion.Compression)
+}
@@ -0,0 +1,107 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"chchma.com/cloudlite-sync/internal/envelope"
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/utils"
+)
+
+// projectDataKey 获取项目当前的数据密钥，项目还没有数据密钥时生成一个并用当前主密钥包装后保存
+func (h *Handler) projectDataKey(projectID string) (keyID string, dataKey []byte, err error) {
+	key, err := h.db.GetActiveProjectKey(projectID)
+	if err != nil {
+		return "", nil, err
+	}
+	if key != nil {
+		dataKey, err = h.keys.Unwrap(key.MasterKeyID, key.WrappedKey)
+		if err != nil {
+			return "", nil, fmt.Errorf("failed to unwrap project key %s: %w", key.ID, err)
+		}
+		return key.ID, dataKey, nil
+	}
+
+	dataKey, err = envelope.GenerateKey()
+	if err != nil {
+		return "", nil, err
+	}
+	masterKeyID, wrapped, err := h.keys.Wrap(dataKey)
+	if err != nil {
+		return "", nil, err
+	}
+	key = &models.ProjectKey{
+		ID:          utils.GenerateUUID(),
+		ProjectID:   projectID,
+		MasterKeyID: masterKeyID,
+		WrappedKey:  wrapped,
+	}
+	if err := h.db.CreateProjectKey(key); err != nil {
+		return "", nil, err
+	}
+	return key.ID, dataKey, nil
+}
+
+// dataKey 解开指定的数据密钥
+func (h *Handler) dataKey(keyID string) ([]byte, error) {
+	key, err := h.db.GetProjectKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("project key %s not found", keyID)
+	}
+	dataKey, err := h.keys.Unwrap(key.MasterKeyID, key.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap project key %s: %w", keyID, err)
+	}
+	return dataKey, nil
+}
+
+// encryptFile 将内容加密到新的临时文件（已删除目录项，关闭后自动释放），返回加密后的大小
+func encryptFile(src io.ReadSeeker, dataKey []byte) (*os.File, int64, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	encrypted, err := os.CreateTemp("", "cloudlite-encrypted-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	os.Remove(encrypted.Name())
+	if err := envelope.Encrypt(encrypted, src, dataKey); err != nil {
+		encrypted.Close()
+		return nil, 0, fmt.Errorf("failed to encrypt file: %w", err)
+	}
+	size, err := encrypted.Seek(0, io.SeekCurrent)
+	if err != nil {
+		encrypted.Close()
+		return nil, 0, err
+	}
+	return encrypted, size, nil
+}
+
+// openStored 打开存储对象并按 keyID 解密，得到加密前（可能仍是压缩）的内容，调用方负责关闭
+func (h *Handler) openStored(ossKey, keyID string) (io.ReadCloser, error) {
+	if keyID == "" {
+		return h.storage.Get(ossKey)
+	}
+	dataKey, err := h.dataKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	object, err := h.storage.Get(ossKey)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := envelope.NewReader(object, dataKey)
+	if err != nil {
+		object.Close()
+		return nil, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{plain, object}, nil
+}
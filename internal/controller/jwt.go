@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/idgen"
 	"chchma.com/cloudlite-sync/internal/models"
 	"chchma.com/cloudlite-sync/internal/session"
 	"chchma.com/cloudlite-sync/internal/utils"
@@ -60,14 +61,17 @@ func (c *JWTController) CreateJWTProject(w http.ResponseWriter, r *http.Request)
 	}
 
 	project := &models.JWTProject{
-		ID:          database.GenerateProjectID(),
 		Name:        name,
 		Description: description,
 		PublicKey:   publicKey,
 		PrivateKey:  privateKey,
 	}
 
-	if err := c.db.CreateJWTProject(project); err != nil {
+	err := idgen.ProjectIDs.Insert(func(id string) error {
+		project.ID = id
+		return c.db.CreateJWTProject(project)
+	})
+	if err != nil {
 		http.Redirect(w, r, "/jwt?error=创建JWT项目失败: "+err.Error(), http.StatusSeeOther)
 		return
 	}
@@ -224,7 +228,6 @@ func (c *JWTController) CreateJWTToken(w http.ResponseWriter, r *http.Request) {
 	}
 
 	token := &models.JWTToken{
-		ID:        database.GenerateJWTTokenID(),
 		ProjectID: projectID,
 		Purpose:   purpose,
 		Username:  username,
@@ -234,7 +237,11 @@ func (c *JWTController) CreateJWTToken(w http.ResponseWriter, r *http.Request) {
 		ExpiresAt: expiresAt,
 	}
 
-	if err := c.db.CreateJWTToken(token); err != nil {
+	err = idgen.JWTTokenIDs.Insert(func(id string) error {
+		token.ID = id
+		return c.db.CreateJWTToken(token)
+	})
+	if err != nil {
 		http.Redirect(w, r, "/jwt/detail?id="+projectID+"&error=创建JWT令牌失败", http.StatusSeeOther)
 		return
 	}
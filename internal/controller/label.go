@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+
+	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// labelPattern 版本标签：字母或数字开头，可包含点、加号、下划线和短横线，兼容 semver（如 1.4.0-beta.1+build.7）
+var labelPattern = regexp.MustCompile(`^[0-9A-Za-z][0-9A-Za-z.+_-]{0,63}$`)
+
+// metadataKeyPattern 元数据键名
+var metadataKeyPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]{1,64}$`)
+
+// 元数据的数量和取值长度上限
+const (
+	maxMetadataEntries = 32
+	maxMetadataValue   = 1024
+)
+
+// metadataFieldPrefix 以 meta.<键名> 表单字段传入元数据
+const metadataFieldPrefix = "meta."
+
+// versionMeta 上传时由客户端指定的版本标签和元数据
+type versionMeta struct {
+	Label    string
+	Metadata map[string]string
+}
+
+// parseVersionMeta 从已解析的表单中读取 label、meta.<键名> 字段，以及每行一个 key=value 的 metadata 字段
+func parseVersionMeta(r *http.Request) (versionMeta, error) {
+	meta := versionMeta{Label: strings.TrimSpace(r.FormValue("label"))}
+	if meta.Label != "" && !labelPattern.MatchString(meta.Label) {
+		return meta, errors.New("invalid label: must start with a letter or digit and contain only letters, digits, '.', '+', '_' or '-' (max 64)")
+	}
+
+	metadata := make(map[string]string)
+	for field, values := range r.Form {
+		if key, ok := strings.CutPrefix(field, metadataFieldPrefix); ok && len(values) > 0 {
+			metadata[key] = values[0]
+		}
+	}
+	for _, line := range strings.Split(r.FormValue("metadata"), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return meta, fmt.Errorf("invalid metadata line %q: expected key=value", line)
+		}
+		metadata[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+
+	if len(metadata) > maxMetadataEntries {
+		return meta, fmt.Errorf("too many metadata entries (max %d)", maxMetadataEntries)
+	}
+	for key, value := range metadata {
+		if !metadataKeyPattern.MatchString(key) {
+			return meta, fmt.Errorf("invalid metadata key %q", key)
+		}
+		if len(value) > maxMetadataValue {
+			return meta, fmt.Errorf("metadata value of %q is too long (max %d bytes)", key, maxMetadataValue)
+		}
+	}
+	if len(metadata) > 0 {
+		meta.Metadata = metadata
+	}
+	return meta, nil
+}
+
+// writeLabelConflict 以 409 返回已占用该标签的版本
+func (h *Handler) writeLabelConflict(w http.ResponseWriter, projectID, label string) {
+	existing, err := h.db.GetVersionByLabel(projectID, label)
+	if err != nil {
+		http.Error(w, "Failed to get version", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusConflict, map[string]interface{}{
+		"success": false,
+		"message": "Label already exists",
+		"version": existing,
+	})
+}
+
+// versionByLabel 获取标签对应的版本，不存在时写入 404 并返回 nil
+func (h *Handler) versionByLabel(w http.ResponseWriter, r *http.Request) *models.DatabaseVersion {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.URL.Query().Get("token"), projectID, models.ScopeRead)
+	if credential == nil {
+		return nil
+	}
+
+	dbVersion, err := h.db.GetVersionByLabel(projectID, chi.URLParam(r, "label"))
+	if err != nil {
+		http.Error(w, "Failed to get version", http.StatusInternalServerError)
+		return nil
+	}
+	if dbVersion == nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return nil
+	}
+	return dbVersion
+}
+
+// ApiDownloadByLabel 下载指定标签的版本
+func (h *Handler) ApiDownloadByLabel(w http.ResponseWriter, r *http.Request) {
+	dbVersion := h.versionByLabel(w, r)
+	if dbVersion == nil {
+		return
+	}
+	h.deliverVersion(w, r, dbVersion)
+}
+
+// ApiGetLabelInfo 获取指定标签的版本信息及元数据
+func (h *Handler) ApiGetLabelInfo(w http.ResponseWriter, r *http.Request) {
+	dbVersion := h.versionByLabel(w, r)
+	if dbVersion == nil {
+		return
+	}
+	if err := h.db.LoadVersionMetadata(dbVersion); err != nil {
+		http.Error(w, "Failed to get version metadata", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"version": dbVersion,
+	})
+}
+
+// isLabelConflict 判断上传是否因标签已被占用而失败
+func isLabelConflict(err error) bool {
+	return errors.Is(err, database.ErrLabelExists)
+}
@@ -0,0 +1,99 @@
+package controller
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"chchma.com/cloudlite-sync/internal/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// ApiPreflight 上传前按文件哈希和大小检查内容是否已存在，客户端据此跳过重复上传。
+// HEAD 请求只返回状态码：已存在为 200（X-Is-Latest 头表示是否为最新版本），不存在为 404；
+// POST 请求返回 JSON，promote=true 时将已存在的版本设为最新版本（需要 promote 权限）。
+// 哈希相同但大小不同时返回 409
+func (h *Handler) ApiPreflight(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.FormValue("token"), projectID, models.ScopeWrite)
+	if credential == nil {
+		return
+	}
+
+	hash := r.FormValue("hash")
+	if hash == "" {
+		http.Error(w, "Parameter hash is required", http.StatusBadRequest)
+		return
+	}
+	size := int64(-1)
+	if value := r.FormValue("size"); value != "" {
+		var err error
+		size, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || size < 0 {
+			http.Error(w, "Invalid size", http.StatusBadRequest)
+			return
+		}
+	}
+	promote := r.FormValue("promote") == "true"
+	if promote && !credential.HasScope(models.ScopePromote) {
+		http.Error(w, "Credential does not have the "+models.ScopePromote+" scope", http.StatusForbidden)
+		return
+	}
+
+	dbVersion, err := h.db.GetVersionByHash(projectID, hash)
+	if err != nil {
+		http.Error(w, "Failed to get version", http.StatusInternalServerError)
+		return
+	}
+	if dbVersion != nil && size >= 0 && dbVersion.FileSize != size {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusConflict)
+			return
+		}
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"success": false,
+			"message": "File size does not match the existing version with this hash",
+			"version": dbVersion,
+		})
+		return
+	}
+
+	if r.Method == http.MethodHead {
+		if dbVersion == nil {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("X-Is-Latest", strconv.FormatBool(dbVersion.IsLatest))
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	if dbVersion == nil {
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"exists":  false,
+		})
+		return
+	}
+
+	response := map[string]interface{}{
+		"success":   true,
+		"exists":    true,
+		"is_latest": dbVersion.IsLatest,
+		"promoted":  false,
+	}
+	if promote && !dbVersion.IsLatest {
+		move, err := h.db.SetLatestVersion(projectID, dbVersion.ID, credentialActor(credential))
+		if err != nil {
+			log.Printf("Failed to promote version %s: %v", dbVersion.ID, err)
+			http.Error(w, "Failed to promote version", http.StatusInternalServerError)
+			return
+		}
+		dbVersion.IsLatest = true
+		response["is_latest"] = true
+		response["promoted"] = true
+		response["move"] = move
+	}
+	response["version"] = dbVersion
+	writeJSON(w, http.StatusOK, response)
+}
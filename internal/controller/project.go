@@ -1,14 +1,16 @@
 package controller
 
 import (
-	"fmt"
+	"errors"
 	"log"
 	"net/http"
+	"net/url"
 	"strconv"
-
-	"io"
+	"strings"
+	"time"
 
 	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/idgen"
 	"chchma.com/cloudlite-sync/internal/models"
 	"chchma.com/cloudlite-sync/internal/session"
 	"chchma.com/cloudlite-sync/internal/template"
@@ -30,7 +32,17 @@ func (h *Handler) Dashboard(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	data := template.NewPageData("数据管理", projects)
+	deletions, err := h.db.ListProjectDeletions(time.Now().Add(-purgedHistory))
+	if err != nil {
+		log.Println("Failed to get project deletions: ", err)
+		http.Error(w, "Failed to get projects", http.StatusInternalServerError)
+		return
+	}
+
+	data := template.NewPageData("数据管理", map[string]interface{}{
+		"projects":  projects,
+		"deletions": deletions,
+	})
 	data.SetUser(session.GetUsername(r))
 	data.SetPagination(page, total, pageSize)
 	data.SetCurrentPage("database")
@@ -116,11 +128,16 @@ func (h *Handler) CreateProject(w http.ResponseWriter, r *http.Request) {
 		Description: description,
 		Website:     website,
 	}
+	var err error
 	if id == "" {
-		project.ID = database.GenerateProjectID()
+		// 自动生成的ID与已有项目重复时重新生成
+		err = idgen.ProjectIDs.Insert(func(id string) error {
+			project.ID = id
+			return h.db.CreateProject(project)
+		})
+	} else {
+		err = h.db.CreateProject(project)
 	}
-
-	err := h.db.CreateProject(project)
 	if err != nil {
 		data := template.NewPageData("创建项目", nil)
 		data.SetUser(session.GetUsername(r))
@@ -178,7 +195,7 @@ func (h *Handler) UpdateProject(w http.ResponseWriter, r *http.Request) {
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// DeleteProject 删除项目
+// DeleteProject 删除项目（移入回收站）
 func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -191,15 +208,75 @@ func (h *Handler) DeleteProject(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err := h.db.DeleteProject(projectID)
+	// 项目先移入回收站，保留期过后由后台任务清理版本、存储对象和凭证
+	retention := time.Duration(h.config.Trash.RetentionHours) * time.Hour
+	_, err := h.db.TrashProject(projectID, session.GetUsername(r), time.Now().Add(retention))
+	if errors.Is(err, database.ErrProjectNotFound) {
+		http.Redirect(w, r, "/?error=项目不存在", http.StatusSeeOther)
+		return
+	}
 	if err != nil {
+		log.Printf("Failed to trash project: %v", err)
 		http.Redirect(w, r, "/?error=删除项目失败", http.StatusSeeOther)
 		return
 	}
+	if retention <= 0 {
+		h.wakePurgeJob()
+	}
 
 	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
+// UpdateProjectSettings 更新项目的同步设置
+func (h *Handler) UpdateProjectSettings(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	projectID := r.FormValue("id")
+	if projectID == "" {
+		http.Redirect(w, r, "/?error=项目ID不能为空", http.StatusSeeOther)
+		return
+	}
+
+	project, err := h.db.GetProject(projectID)
+	if err != nil {
+		http.Redirect(w, r, "/?error=获取项目失败", http.StatusSeeOther)
+		return
+	}
+	if project == nil {
+		http.Redirect(w, r, "/?error=项目不存在", http.StatusSeeOther)
+		return
+	}
+
+	downloadMode := r.FormValue("download_mode")
+	switch downloadMode {
+	case models.DownloadModeProxy, models.DownloadModeRedirect, models.DownloadModeURL:
+	default:
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=下载模式不正确", http.StatusSeeOther)
+		return
+	}
+
+	retainCount, err1 := parseRetention(r.FormValue("retain_count"))
+	retainDays, err2 := parseRetention(r.FormValue("retain_days"))
+	if err1 != nil || err2 != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=保留策略必须是非负整数", http.StatusSeeOther)
+		return
+	}
+
+	project.DownloadMode = downloadMode
+	project.RequiredTables = strings.Join(utils.SplitList(r.FormValue("required_tables")), ",")
+	project.RetainCount = retainCount
+	project.RetainDays = retainDays
+	if err := h.db.UpdateProject(project); err != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=更新项目设置失败", http.StatusSeeOther)
+		return
+	}
+
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
+
 // ProjectDetail 显示项目详情
 func (h *Handler) ProjectDetail(w http.ResponseWriter, r *http.Request) {
 	projectID := r.URL.Query().Get("id")
@@ -240,15 +317,44 @@ func (h *Handler) ProjectDetail(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/?error=获取版本失败", http.StatusSeeOther)
 		return
 	}
+	if err := h.db.LoadVersionMetadata(versions...); err != nil {
+		log.Printf("Failed to load version metadata for project %s: %v", projectID, err)
+	}
+
+	// 保留策略预览和最近的清理记录
+	_, pruneCandidates, err := h.planRetention(project)
+	if err != nil {
+		log.Printf("Failed to plan retention for project %s: %v", projectID, err)
+	}
+	prunedVersions, err := h.db.ListPrunedVersions(projectID, 20)
+	if err != nil {
+		log.Printf("Failed to list pruned versions for project %s: %v", projectID, err)
+	}
+
+	// 发布渠道及变更记录
+	channels, err := h.loadChannels(projectID)
+	if err != nil {
+		log.Printf("Failed to list channels for project %s: %v", projectID, err)
+	}
+	channelMoves, err := h.db.ListChannelMoves(projectID, "", 20)
+	if err != nil {
+		log.Printf("Failed to list channel moves for project %s: %v", projectID, err)
+	}
 
 	data := map[string]interface{}{
-		"project":      project,
-		"credentials":  credentials,
-		"versions":     versions,
-		"credTotal":    credTotal,
-		"versionTotal": versionTotal,
-		"credPage":     credPage,
-		"versionPage":  versionPage,
+		"project":         project,
+		"credentials":     credentials,
+		"newCredential":   &models.Credential{Scopes: strings.Join(models.CredentialScopes, ",")},
+		"versions":        versions,
+		"credTotal":       credTotal,
+		"versionTotal":    versionTotal,
+		"credPage":        credPage,
+		"versionPage":     versionPage,
+		"pruneCandidates": pruneCandidates,
+		"prunedVersions":  prunedVersions,
+		"channels":        channels,
+		"channelMoves":    channelMoves,
+		"newToken":        session.PopSecretFlash(w, r, newTokenFlash),
 	}
 
 	pageData := template.NewPageData("项目详情", data)
@@ -269,6 +375,15 @@ func (h *Handler) UploadDatabaseVersion(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	if err := h.parseUploadForm(w, r); err != nil {
+		msg := "解析上传表单失败"
+		if isTooLarge(err) {
+			msg = "文件超出大小限制"
+		}
+		http.Redirect(w, r, "/?error="+msg, http.StatusSeeOther)
+		return
+	}
+
 	projectID := r.FormValue("project_id")
 	description := r.FormValue("description")
 	if projectID == "" {
@@ -282,56 +397,35 @@ func (h *Handler) UploadDatabaseVersion(w http.ResponseWriter, r *http.Request)
 		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=无法找到项目", http.StatusSeeOther)
 		return
 	}
-
-	file, header, err := r.FormFile("database")
+	meta, err := parseVersionMeta(r)
 	if err != nil {
-		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=获取上传文件失败", http.StatusSeeOther)
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error="+url.QueryEscape("标签或元数据无效："+err.Error()), http.StatusSeeOther)
 		return
 	}
-	defer file.Close()
 
-	fileData, err := io.ReadAll(file)
+	file, header, err := r.FormFile("database")
 	if err != nil {
-		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=读取文件失败", http.StatusSeeOther)
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=获取上传文件失败", http.StatusSeeOther)
 		return
 	}
+	defer file.Close()
 
-	fileHash := utils.GenerateHash(fileData)
-	// 检查文件是否已存在
-	existingVersion, err := h.db.GetVersionByHash(projectID, fileHash)
-	if err != nil {
-		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=检查版本失败", http.StatusSeeOther)
+	_, existingVersion, err := h.storeVersion(projectID, header.Filename, description, meta, file)
+	if validationErr := validationError(err); validationErr != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error="+url.QueryEscape("文件校验失败："+validationErr.Error()), http.StatusSeeOther)
 		return
 	}
-	if existingVersion != nil {
-		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=文件已存在", http.StatusSeeOther)
+	if isLabelConflict(err) {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error="+url.QueryEscape("标签 "+meta.Label+" 已被其他版本使用"), http.StatusSeeOther)
 		return
 	}
-
-	version := utils.GenerateVersion()
-	ossKey := utils.GenerateOSSKey(projectID, version, header.Filename)
-	// 上传到OSS
-	err = h.ossClient.UploadFile(ossKey, fileData)
 	if err != nil {
+		log.Printf("Failed to store database version: %v", err)
 		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=上传文件失败", http.StatusSeeOther)
 		return
 	}
-
-	dbVersion := &models.DatabaseVersion{
-		ID:          utils.GenerateUUID(),
-		ProjectID:   projectID,
-		Version:     version,
-		FileHash:    fileHash,
-		FileName:    header.Filename,
-		FileSize:    header.Size,
-		OSSKey:      ossKey,
-		Description: description,
-		IsLatest:    true,
-	}
-	err = h.db.CreateDatabaseVersion(dbVersion)
-	if err != nil {
-		h.ossClient.DeleteFile(ossKey)
-		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=保存版本记录失败", http.StatusSeeOther)
+	if existingVersion != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=文件已存在", http.StatusSeeOther)
 		return
 	}
 
@@ -356,14 +450,53 @@ func (h *Handler) DeleteDatabaseVersion(w http.ResponseWriter, r *http.Request)
 		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=版本不存在", http.StatusSeeOther)
 		return
 	}
-	// 删除OSS文件
-	h.ossClient.DeleteFile(version.OSSKey)
-	// 删除数据库记录
-	err = h.db.DeleteDatabaseVersion(versionID)
+	// 渠道指向的版本需要先将渠道移走
+	channels, err := h.db.ListVersionChannels(versionID)
 	if err != nil {
 		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=删除版本失败", http.StatusSeeOther)
 		return
 	}
+	if len(channels) > 0 {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error="+url.QueryEscape("版本正被渠道 "+strings.Join(channels, "、")+" 使用"), http.StatusSeeOther)
+		return
+	}
+	if err := h.removeVersion(version); err != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=删除版本失败", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
+
+// removeVersion 删除版本的差量缓存和数据库记录，存储文件不再被其他版本引用时一并删除
+func (h *Handler) removeVersion(version *models.DatabaseVersion) error {
+	h.deleteVersionDeltas(version.ID)
+	unreferenced, err := h.db.DeleteDatabaseVersion(version.ID)
+	if err != nil {
+		return err
+	}
+	if unreferenced {
+		h.deleteUnreferencedObject(version.OSSKey)
+	}
+	return nil
+}
+
+// PinDatabaseVersion 网页端固定或取消固定版本，固定的版本不会被保留策略清理
+func (h *Handler) PinDatabaseVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	versionID := r.FormValue("id")
+	projectID := r.FormValue("project_id")
+	version, err := h.db.GetDatabaseVersion(versionID)
+	if err != nil || version == nil || version.ProjectID != projectID {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=版本不存在", http.StatusSeeOther)
+		return
+	}
+	if err := h.db.SetVersionPinned(versionID, r.FormValue("pinned") == "true"); err != nil {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=更新版本失败", http.StatusSeeOther)
+		return
+	}
 	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
 }
 
@@ -396,15 +529,10 @@ func (h *Handler) ProjectDownload(w http.ResponseWriter, r *http.Request) {
 		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=版本不存在", http.StatusSeeOther)
 		return
 	}
-	fileData, err := h.ossClient.DownloadFile(dbVersion.OSSKey)
-	if err != nil {
+	if err := h.sendVersionFile(w, r, dbVersion); err != nil {
 		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=下载文件失败", http.StatusSeeOther)
 		return
 	}
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s", dbVersion.FileName))
-	w.Header().Set("Content-Length", strconv.FormatInt(dbVersion.FileSize, 10))
-	w.Write(fileData)
 }
 
 func (h *Handler) HelpPage(w http.ResponseWriter, r *http.Request) {
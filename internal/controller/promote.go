@@ -0,0 +1,120 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+
+	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/session"
+	"github.com/go-chi/chi/v5"
+)
+
+// ApiPromoteVersion 将指定哈希的已有版本设为最新版本
+func (h *Handler) ApiPromoteVersion(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.FormValue("token"), projectID, models.ScopePromote)
+	if credential == nil {
+		return
+	}
+
+	hash := r.FormValue("hash")
+	if hash == "" {
+		http.Error(w, "Parameter hash is required", http.StatusBadRequest)
+		return
+	}
+	dbVersion, err := h.db.GetVersionByHash(projectID, hash)
+	if err != nil {
+		http.Error(w, "Failed to get version", http.StatusInternalServerError)
+		return
+	}
+	if dbVersion == nil {
+		http.Error(w, "Version not found", http.StatusNotFound)
+		return
+	}
+
+	move, err := h.db.SetLatestVersion(projectID, dbVersion.ID, credentialActor(credential))
+	if err != nil {
+		log.Printf("Failed to promote version %s: %v", dbVersion.ID, err)
+		http.Error(w, "Failed to promote version", http.StatusInternalServerError)
+		return
+	}
+	dbVersion.IsLatest = true
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"move":    move,
+		"version": dbVersion,
+	})
+}
+
+// ApiRollbackLatest 将最新版本回滚到上一次变更之前的版本
+func (h *Handler) ApiRollbackLatest(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.FormValue("token"), projectID, models.ScopePromote)
+	if credential == nil {
+		return
+	}
+
+	move, err := h.db.RollbackLatestVersion(projectID, credentialActor(credential))
+	if errors.Is(err, database.ErrNoPreviousVersion) {
+		http.Error(w, "No previous version to roll back to", http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to roll back latest version: %v", err)
+		http.Error(w, "Failed to roll back latest version", http.StatusInternalServerError)
+		return
+	}
+	dbVersion, err := h.db.GetDatabaseVersion(move.ToVersionID)
+	if err != nil {
+		http.Error(w, "Failed to get version", http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"move":    move,
+		"version": dbVersion,
+	})
+}
+
+// PromoteDatabaseVersion 网页端将版本设为最新
+func (h *Handler) PromoteDatabaseVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := r.FormValue("project_id")
+	versionID := r.FormValue("id")
+	version, err := h.db.GetDatabaseVersion(versionID)
+	if err != nil || version == nil || version.ProjectID != projectID {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=版本不存在", http.StatusSeeOther)
+		return
+	}
+	if _, err := h.db.SetLatestVersion(projectID, versionID, session.GetUsername(r)); err != nil {
+		log.Printf("Failed to promote version %s: %v", versionID, err)
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=设置最新版本失败", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
+
+// RollbackLatestVersion 网页端将最新版本回滚到上一个版本
+func (h *Handler) RollbackLatestVersion(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := r.FormValue("project_id")
+	_, err := h.db.RollbackLatestVersion(projectID, session.GetUsername(r))
+	if errors.Is(err, database.ErrNoPreviousVersion) {
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=没有可回滚的版本", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to roll back latest version: %v", err)
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=回滚最新版本失败", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
@@ -0,0 +1,267 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	"chchma.com/cloudlite-sync/config"
+	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/envelope"
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/storage"
+	"chchma.com/cloudlite-sync/internal/utils"
+)
+
+// managedPrefixes 由服务写入的存储前缀：版本文件、共享对象、差量缓存和分片上传的临时对象
+var managedPrefixes = []string{"store/", "blobs/", "deltas/", "uploads/"}
+
+// orphanGracePeriod 写入存储后到数据库记录提交前的对象可能属于进行中的上传，比这更新的对象不视为孤立对象
+const orphanGracePeriod = time.Hour
+
+// ReconcileOptions 存储一致性检查的选项
+type ReconcileOptions struct {
+	Repair     bool // 删除孤立对象、标记损坏的版本、删除丢失的差量缓存并修正引用计数
+	VerifyHash bool // 读取对象内容校验哈希
+}
+
+// Reconcile 不启动 HTTP 服务执行一次存储一致性检查，供命令行使用
+func Reconcile(cfg *config.Config, db *database.DB, store storage.Storage, keys *envelope.Keyring, opts ReconcileOptions) (*models.ReconcileReport, error) {
+	h := &Handler{config: cfg, db: db, storage: store, keys: keys}
+	return h.reconcile(opts)
+}
+
+// startReconcileJob 按固定间隔执行存储一致性检查
+func (h *Handler) startReconcileJob(interval time.Duration, opts ReconcileOptions) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		report, err := h.reconcile(opts)
+		if err != nil {
+			log.Printf("Reconcile: %v", err)
+			continue
+		}
+		logReconcileReport(report)
+	}
+}
+
+// logReconcileReport 记录检查发现的问题和汇总
+func logReconcileReport(report *models.ReconcileReport) {
+	repaired := 0
+	for _, issue := range report.Issues {
+		if issue.Repaired {
+			repaired++
+		}
+		log.Printf("Reconcile: %s %s %s (repaired: %t) %s", issue.Kind, issue.OSSKey, issue.Detail, issue.Repaired, issue.Error)
+	}
+	for _, message := range report.Errors {
+		log.Printf("Reconcile: %s", message)
+	}
+	log.Printf("Reconcile: scanned %d objects and %d versions, found %d issues, repaired %d, %d errors",
+		report.ObjectsScanned, report.VersionsChecked, len(report.Issues), repaired, len(report.Errors))
+}
+
+// reconcile 对比存储中的对象和数据库记录，报告孤立对象、丢失或损坏的版本文件、
+// 丢失的差量缓存和错误的引用计数，启用修复时一并处理
+func (h *Handler) reconcile(opts ReconcileOptions) (*models.ReconcileReport, error) {
+	report := &models.ReconcileReport{
+		Repair:     opts.Repair,
+		VerifyHash: opts.VerifyHash,
+		Issues:     []*models.ReconcileIssue{},
+		StartedAt:  time.Now(),
+	}
+
+	// 先列出对象再读取引用，列出之后才提交的上传不会被误判为孤立对象
+	var objects []*storage.ObjectInfo
+	for _, prefix := range managedPrefixes {
+		listed, err := h.storage.List(prefix)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, listed...)
+	}
+	report.ObjectsScanned = len(objects)
+	referenced, err := h.db.ListReferencedObjectKeys()
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range objects {
+		if referenced[object.Key] || report.StartedAt.Sub(object.LastModified) < orphanGracePeriod {
+			continue
+		}
+		issue := &models.ReconcileIssue{
+			Kind:   models.IssueOrphan,
+			OSSKey: object.Key,
+			Detail: fmt.Sprintf("%d bytes, modified %s", object.Size, utils.FormatTime(object.LastModified)),
+		}
+		if opts.Repair {
+			repairIssue(issue, h.storage.Delete(object.Key))
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+
+	if err := h.reconcileVersions(report, opts); err != nil {
+		return nil, err
+	}
+	if err := h.reconcileDeltas(report, opts); err != nil {
+		return nil, err
+	}
+	if err := h.reconcileBlobs(report, opts); err != nil {
+		return nil, err
+	}
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// repairIssue 记录修复的结果
+func repairIssue(issue *models.ReconcileIssue, err error) {
+	if err != nil {
+		issue.Error = err.Error()
+		return
+	}
+	issue.Repaired = true
+}
+
+// reconcileVersions 检查每个版本的存储对象，修复时标记有问题的版本，并清除已恢复正常的版本上的标记
+func (h *Handler) reconcileVersions(report *models.ReconcileReport, opts ReconcileOptions) error {
+	versions, err := h.db.ListStoredVersions()
+	if err != nil {
+		return err
+	}
+
+	type result struct {
+		kind, detail string
+		err          error
+	}
+	// 共享对象被多个版本引用，只检查一次
+	checked := make(map[string]result)
+	for _, version := range versions {
+		report.VersionsChecked++
+		r, ok := checked[version.OSSKey]
+		if !ok {
+			r.kind, r.detail, r.err = h.checkVersionObject(version, opts.VerifyHash)
+			checked[version.OSSKey] = r
+		}
+		if r.err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("version %s (%s): %v", version.ID, version.OSSKey, r.err))
+			continue
+		}
+
+		if r.kind == "" {
+			// 未校验哈希时无法确认内容已恢复，保留哈希不一致的标记
+			if opts.Repair && version.Broken != "" && (opts.VerifyHash || version.Broken != models.IssueHashMismatch) {
+				if err := h.db.SetVersionBroken(version.ID, ""); err != nil {
+					report.Errors = append(report.Errors, err.Error())
+				} else {
+					log.Printf("Reconcile: version %s is no longer %s", version.ID, version.Broken)
+				}
+			}
+			continue
+		}
+		issue := &models.ReconcileIssue{
+			Kind:      r.kind,
+			OSSKey:    version.OSSKey,
+			ProjectID: version.ProjectID,
+			VersionID: version.ID,
+			Detail:    r.detail,
+		}
+		if opts.Repair {
+			repairIssue(issue, h.db.SetVersionBroken(version.ID, r.kind))
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	return nil
+}
+
+// checkVersionObject 检查版本的存储对象是否存在、大小是否一致，verifyHash 时读取内容核对哈希和文件大小。
+// 返回问题类型，没有问题时为空；无法完成检查（如存储或解密失败）时返回错误
+func (h *Handler) checkVersionObject(version *models.DatabaseVersion, verifyHash bool) (kind, detail string, err error) {
+	info, err := h.storage.Stat(version.OSSKey)
+	if errors.Is(err, storage.ErrNotFound) {
+		return models.IssueMissing, "", nil
+	}
+	if err != nil {
+		return "", "", err
+	}
+	if info.Size != version.StoredSize {
+		return models.IssueSizeMismatch, fmt.Sprintf("stored %d bytes, expected %d", info.Size, version.StoredSize), nil
+	}
+	if !verifyHash {
+		return "", "", nil
+	}
+
+	object, err := h.openVersion(version)
+	if err != nil {
+		return "", "", err
+	}
+	hr := utils.NewHashReader(object)
+	_, err = io.Copy(io.Discard, hr)
+	object.Close()
+	if err != nil {
+		return "", "", err
+	}
+	if hr.Hash() != version.FileHash || (version.SHA256 != "" && hr.SHA256() != version.SHA256) || hr.Size() != version.FileSize {
+		return models.IssueHashMismatch, fmt.Sprintf("content has md5 %s, sha256 %s, %d bytes", hr.Hash(), hr.SHA256(), hr.Size()), nil
+	}
+	return "", "", nil
+}
+
+// reconcileDeltas 检查差量缓存的存储对象，修复时删除对象丢失的缓存记录，下次请求时重新生成
+func (h *Handler) reconcileDeltas(report *models.ReconcileReport, opts ReconcileOptions) error {
+	deltas, err := h.db.ListCachedDeltas()
+	if err != nil {
+		return err
+	}
+	for _, delta := range deltas {
+		exists, err := h.storage.Exists(delta.OSSKey)
+		if err != nil {
+			report.Errors = append(report.Errors, fmt.Sprintf("delta %s (%s): %v", delta.ID, delta.OSSKey, err))
+			continue
+		}
+		if exists {
+			continue
+		}
+		issue := &models.ReconcileIssue{
+			Kind:      models.IssueMissing,
+			OSSKey:    delta.OSSKey,
+			ProjectID: delta.ProjectID,
+			VersionID: delta.ID,
+			Detail:    "cached delta",
+		}
+		if opts.Repair {
+			repairIssue(issue, h.db.DeleteVersionDelta(delta.ID))
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	return nil
+}
+
+// reconcileBlobs 核对共享对象的引用计数与实际引用的版本数，修复时重新计数
+func (h *Handler) reconcileBlobs(report *models.ReconcileReport, opts ReconcileOptions) error {
+	blobs, counts, err := h.db.ListBlobReferences()
+	if err != nil {
+		return err
+	}
+	for _, blob := range blobs {
+		if blob.RefCount == counts[blob.OSSKey] {
+			continue
+		}
+		// 上传时先取得引用再创建版本，最近更新过的对象多出的引用可能属于进行中的上传
+		if blob.RefCount > counts[blob.OSSKey] && report.StartedAt.Sub(blob.UpdatedAt) < orphanGracePeriod {
+			continue
+		}
+		issue := &models.ReconcileIssue{
+			Kind:   models.IssueRefCount,
+			OSSKey: blob.OSSKey,
+			Detail: fmt.Sprintf("ref_count %d, referenced by %d versions", blob.RefCount, counts[blob.OSSKey]),
+		}
+		if opts.Repair {
+			repairIssue(issue, h.db.RecountBlobReferences(blob.SHA256))
+		}
+		report.Issues = append(report.Issues, issue)
+	}
+	return nil
+}
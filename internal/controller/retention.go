@@ -0,0 +1,160 @@
+package controller
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/retention"
+	"chchma.com/cloudlite-sync/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// 清理的触发方式
+const (
+	pruneTriggerScheduled = "scheduled"
+	pruneTriggerManual    = "manual"
+)
+
+// startRetentionJob 按固定间隔对设置了保留策略的项目执行清理
+func (h *Handler) startRetentionJob(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		projects, err := h.db.ListRetentionProjects()
+		if err != nil {
+			log.Printf("Retention: failed to list projects: %v", err)
+			continue
+		}
+		for _, project := range projects {
+			if _, err := h.pruneProject(project, pruneTriggerScheduled); err != nil {
+				log.Printf("Retention: failed to prune project %s: %v", project.ID, err)
+			}
+		}
+	}
+}
+
+// planRetention 按项目的保留策略计算保留和待清理的版本
+func (h *Handler) planRetention(project *models.Project) (keep, prune []*retention.Decision, err error) {
+	versions, err := h.db.ListAllVersions(project.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	channels, err := h.db.ListChannels(project.ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	protected := make(map[string]string)
+	for _, channel := range channels {
+		if reason, ok := protected[channel.VersionID]; ok {
+			protected[channel.VersionID] = reason + ", channel " + channel.Name
+		} else {
+			protected[channel.VersionID] = "channel " + channel.Name
+		}
+	}
+	keep, prune = retention.Plan(versions, retention.ProjectPolicy(project), protected, time.Now())
+	return keep, prune, nil
+}
+
+// pruneProject 删除不在保留策略内的版本并记录清理日志，返回实际清理的版本
+func (h *Handler) pruneProject(project *models.Project, trigger string) ([]*retention.Decision, error) {
+	_, prune, err := h.planRetention(project)
+	if err != nil {
+		return nil, err
+	}
+
+	var pruned []*retention.Decision
+	var errs []error
+	for _, decision := range prune {
+		version := decision.Version
+		if err := h.removeVersion(version); err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		pruned = append(pruned, decision)
+		log.Printf("Retention: pruned version %s (%s) of project %s: %s", version.Version, version.FileHash, project.ID, decision.Reason)
+
+		record := &models.PrunedVersion{
+			ID:        utils.GenerateUUID(),
+			ProjectID: project.ID,
+			VersionID: version.ID,
+			Version:   version.Version,
+			FileHash:  version.FileHash,
+			FileName:  version.FileName,
+			FileSize:  version.FileSize,
+			Reason:    decision.Reason,
+			Trigger:   trigger,
+			CreatedAt: version.CreatedAt,
+		}
+		if err := h.db.CreatePrunedVersion(record); err != nil {
+			log.Printf("Retention: failed to record pruned version %s: %v", version.ID, err)
+		}
+	}
+	return pruned, errors.Join(errs...)
+}
+
+// ApiRetentionPreview 预览按当前保留策略会保留和清理的版本，不做任何删除
+func (h *Handler) ApiRetentionPreview(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.URL.Query().Get("token"), projectID, models.ScopeList)
+	if credential == nil {
+		return
+	}
+
+	project, err := h.db.GetProject(projectID)
+	if err != nil || project == nil {
+		http.Error(w, "Failed to get project", http.StatusInternalServerError)
+		return
+	}
+	keep, prune, err := h.planRetention(project)
+	if err != nil {
+		http.Error(w, "Failed to plan retention", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"dry_run": true,
+		"policy":  retention.ProjectPolicy(project),
+		"keep":    keep,
+		"prune":   prune,
+	})
+}
+
+// PruneProjectVersions 网页端立即按保留策略清理版本
+func (h *Handler) PruneProjectVersions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	projectID := r.FormValue("project_id")
+	project, err := h.db.GetProject(projectID)
+	if err != nil || project == nil {
+		http.Redirect(w, r, "/?error=项目不存在", http.StatusSeeOther)
+		return
+	}
+	if _, err := h.pruneProject(project, pruneTriggerManual); err != nil {
+		log.Printf("Failed to prune project %s: %v", projectID, err)
+		http.Redirect(w, r, "/project/detail?id="+projectID+"&error=部分版本清理失败", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/project/detail?id="+projectID, http.StatusSeeOther)
+}
+
+// parseRetention 解析保留策略的数值，留空为 0
+func parseRetention(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("retention must be a non-negative integer")
+	}
+	return n, nil
+}
@@ -1,35 +1,93 @@
 package controller
 
 import (
+	"log"
+	"net"
 	"net/http"
+	"time"
 
 	"chchma.com/cloudlite-sync/config"
 	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/envelope"
+	"chchma.com/cloudlite-sync/internal/idgen"
 	m "chchma.com/cloudlite-sync/internal/middleware"
-	"chchma.com/cloudlite-sync/internal/oss"
 	"chchma.com/cloudlite-sync/internal/session"
+	"chchma.com/cloudlite-sync/internal/storage"
 	"chchma.com/cloudlite-sync/internal/template"
+	"chchma.com/cloudlite-sync/internal/utils"
 	"github.com/go-chi/chi/v5"
 	cm "github.com/go-chi/chi/v5/middleware"
 )
 
 type Handler struct {
-	config    *config.Config
-	db        *database.DB
-	ossClient *oss.OSSClient
-	tmpl      *template.TemplateEngine
-	jwtCtrl   *JWTController
+	config  *config.Config
+	db      *database.DB
+	storage storage.Storage
+	tmpl    *template.TemplateEngine
+	jwtCtrl *JWTController
+	keys    *envelope.Keyring // 未配置主密钥时不加密
+	// trustedProxies 可信反向代理的地址段，用于识别客户端地址
+	trustedProxies []*net.IPNet
+	tokens         *idgen.Generator // 凭证令牌生成器
+	purgeNow       chan struct{}    // 通知回收站清理任务立即检查
+	blobLocks      keyLocks         // 串行化共享对象的取得引用和删除
 }
 
-func NewRouter(cfg *config.Config, db *database.DB, ossClient *oss.OSSClient) *chi.Mux {
+// minTokenLength 凭证令牌的最小长度
+const minTokenLength = 16
+
+func NewRouter(cfg *config.Config, db *database.DB, store storage.Storage, keys *envelope.Keyring) *chi.Mux {
 	session.Init(cfg.SessionSecret)
 
+	trustedProxies, err := utils.ParseCIDRs(cfg.Server.TrustedProxies)
+	if err != nil {
+		log.Fatalf("Invalid trusted proxies: %v", err)
+	}
+	if cfg.Credential.TokenLength < minTokenLength {
+		log.Fatalf("Invalid credential token length: must be at least %d", minTokenLength)
+	}
+	tokens, err := idgen.New(cfg.Credential.TokenAlphabet, cfg.Credential.TokenLength)
+	if err != nil {
+		log.Fatalf("Invalid credential token config: %v", err)
+	}
+
 	handler := &Handler{
-		config:    cfg,
-		db:        db,
-		ossClient: ossClient,
-		tmpl:      template.New(),
-		jwtCtrl:   NewJWTController(db),
+		config:         cfg,
+		db:             db,
+		storage:        store,
+		tmpl:           template.New(),
+		jwtCtrl:        NewJWTController(db),
+		keys:           keys,
+		trustedProxies: trustedProxies,
+		tokens:         tokens,
+		purgeNow:       make(chan struct{}, 1),
+	}
+
+	// 为升级前的版本补算 SHA-256 并读取 schema 版本
+	go func() {
+		handler.backfillSHA256()
+		handler.backfillSchemaVersion()
+	}()
+
+	// 版本保留策略的后台清理任务
+	if cfg.Retention.IntervalMinutes > 0 {
+		go handler.startRetentionJob(time.Duration(cfg.Retention.IntervalMinutes) * time.Minute)
+	}
+
+	// 过期分片上传会话的后台清理任务
+	if cfg.Upload.SessionTTLHours > 0 {
+		go handler.startUploadExpiryJob()
+	}
+
+	// 回收站的后台清理任务
+	go handler.startPurgeJob()
+
+	// 存储一致性检查的后台任务
+	if cfg.Reconcile.IntervalMinutes > 0 {
+		go handler.startReconcileJob(time.Duration(cfg.Reconcile.IntervalMinutes)*time.Minute, ReconcileOptions{
+			Repair:     cfg.Reconcile.Repair,
+			VerifyHash: cfg.Reconcile.VerifyHash,
+		})
 	}
 
 	r := chi.NewRouter()
@@ -61,11 +119,22 @@ func NewRouter(cfg *config.Config, db *database.DB, ossClient *oss.OSSClient) *c
 		r.Route("/project", func(r chi.Router) {
 			r.Post("/create", handler.CreateProject)
 			r.Post("/update", handler.UpdateProject)
+			r.Post("/settings", handler.UpdateProjectSettings)
 			r.Post("/delete", handler.DeleteProject)
+			r.Post("/restore", handler.RestoreProject)
+			r.Post("/purge", handler.PurgeProject)
 			r.Get("/detail", handler.ProjectDetail)
 			r.Post("/upload_version", handler.UploadDatabaseVersion)
 			r.Post("/delete_version", handler.DeleteDatabaseVersion)
+			r.Post("/pin_version", handler.PinDatabaseVersion)
+			r.Post("/promote_version", handler.PromoteDatabaseVersion)
+			r.Post("/rollback_latest", handler.RollbackLatestVersion)
+			r.Post("/prune", handler.PruneProjectVersions)
+			r.Post("/channel/set", handler.SetProjectChannel)
+			r.Post("/channel/rollback", handler.RollbackProjectChannel)
+			r.Post("/channel/delete", handler.DeleteProjectChannel)
 			r.Get("/download", handler.ProjectDownload)
+			r.Get("/diff", handler.VersionDiffPage)
 		})
 
 		// 凭证管理
@@ -74,6 +143,8 @@ func NewRouter(cfg *config.Config, db *database.DB, ossClient *oss.OSSClient) *c
 			r.Post("/delete", handler.DeleteCredential)
 			r.Post("/activate", handler.ActivateCredential)
 			r.Post("/deactivate", handler.DeactivateCredential)
+			r.Post("/scopes", handler.UpdateCredentialScopes)
+			r.Post("/update", handler.UpdateCredential)
 		})
 
 		// JWT项目管理
@@ -107,10 +178,30 @@ func NewRouter(cfg *config.Config, db *database.DB, ossClient *oss.OSSClient) *c
 	// API路由（第三方访问）
 	r.Route("/api", func(r chi.Router) {
 		r.Post("/{projectID}", handler.ApiUploadDatabase)
+		r.Head("/{projectID}/preflight", handler.ApiPreflight)
+		r.Post("/{projectID}/preflight", handler.ApiPreflight)
 		r.Get("/{projectID}/latest", handler.ApiDownloadLatest)
+		r.Get("/{projectID}/delta", handler.ApiDownloadDelta)
+		r.Get("/{projectID}/diff", handler.ApiVersionDiff)
+		r.Get("/{projectID}/retention", handler.ApiRetentionPreview)
+		r.Post("/{projectID}/promote", handler.ApiPromoteVersion)
+		r.Post("/{projectID}/rollback", handler.ApiRollbackLatest)
+		r.Get("/{projectID}/channels", handler.ApiListChannels)
+		r.Get("/{projectID}/channel/{name}", handler.ApiDownloadChannel)
+		r.Post("/{projectID}/channel/{name}", handler.ApiSetChannel)
+		r.Post("/{projectID}/channel/{name}/rollback", handler.ApiRollbackChannel)
+		r.Get("/{projectID}/label/{label}", handler.ApiDownloadByLabel)
+		r.Get("/{projectID}/label/{label}/info", handler.ApiGetLabelInfo)
 		r.Get("/{projectID}/{hash}", handler.ApiDownloadByHash)
 		r.Get("/{projectID}/versions", handler.ApiListVersions)
 		r.Get("/{projectID}/info/{hash}", handler.ApiGetVersionInfo)
+
+		// 分片上传（断点续传）
+		r.Post("/{projectID}/uploads", handler.ApiCreateUpload)
+		r.Get("/{projectID}/uploads/{uploadID}", handler.ApiGetUpload)
+		r.Put("/{projectID}/uploads/{uploadID}/parts/{partNumber}", handler.ApiUploadPart)
+		r.Post("/{projectID}/uploads/{uploadID}/complete", handler.ApiCompleteUpload)
+		r.Delete("/{projectID}/uploads/{uploadID}", handler.ApiAbortUpload)
 	})
 	return r
 }
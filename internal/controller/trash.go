@@ -0,0 +1,154 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/models"
+)
+
+// purgeCheckInterval 后台任务检查到期的回收站项目的间隔
+const purgeCheckInterval = time.Minute
+
+// purgedHistory 控制台显示最近多长时间内清理完成的项目
+const purgedHistory = 7 * 24 * time.Hour
+
+// projectPrefixes 按项目划分的存储前缀：加密或升级前上传的版本文件、差量缓存和分片上传的临时对象
+var projectPrefixes = []string{"store/", "deltas/", "uploads/"}
+
+// startPurgeJob 定期清理到期的回收站项目，启动时先继续上次未完成的清理，收到 purgeNow 信号时立即检查
+func (h *Handler) startPurgeJob() {
+	ticker := time.NewTicker(purgeCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		deletions, err := h.db.ListDuePurges(time.Now())
+		if err != nil {
+			log.Printf("Purge: failed to list projects: %v", err)
+		}
+		for _, deletion := range deletions {
+			if err := h.purgeProject(deletion); err != nil {
+				log.Printf("Purge: failed to purge project %s: %v", deletion.ProjectID, err)
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-h.purgeNow:
+		}
+	}
+}
+
+// wakePurgeJob 通知后台任务立即检查，任务正在执行时合并为下一次检查
+func (h *Handler) wakePurgeJob() {
+	select {
+	case h.purgeNow <- struct{}{}:
+	default:
+	}
+}
+
+// purgeProject 清理回收站中的项目，中途失败时记录原因，下次从剩余的版本继续
+func (h *Handler) purgeProject(deletion *models.ProjectDeletion) error {
+	started, err := h.db.StartPurge(deletion.ProjectID)
+	if err != nil {
+		return err
+	}
+	if !started {
+		// 已从回收站恢复
+		return nil
+	}
+	log.Printf("Purge: purging project %s (%s)", deletion.ProjectID, deletion.ProjectName)
+
+	if err := h.purgeProjectData(deletion); err != nil {
+		if err := h.db.SetPurgeProgress(deletion.ProjectID, deletion.PurgedVersions, err.Error()); err != nil {
+			log.Printf("Purge: %v", err)
+		}
+		return err
+	}
+	log.Printf("Purge: purged project %s, %d versions", deletion.ProjectID, deletion.PurgedVersions)
+	return nil
+}
+
+// purgeProjectData 逐个删除项目的版本（释放共享对象的引用，删除不再被引用的存储对象）并记录进度，
+// 再删除项目前缀下残留的存储对象，最后删除凭证、渠道等其余数据记录和项目本身
+func (h *Handler) purgeProjectData(deletion *models.ProjectDeletion) error {
+	versions, err := h.db.ListAllVersions(deletion.ProjectID)
+	if err != nil {
+		return err
+	}
+	// 上次中断前已删除的版本计入进度
+	deletion.PurgedVersions = max(deletion.TotalVersions-len(versions), 0)
+	for _, version := range versions {
+		if err := h.removeVersion(version); err != nil {
+			return fmt.Errorf("failed to remove version %s: %w", version.ID, err)
+		}
+		deletion.PurgedVersions++
+		if err := h.db.SetPurgeProgress(deletion.ProjectID, deletion.PurgedVersions, ""); err != nil {
+			return err
+		}
+	}
+
+	for _, prefix := range projectPrefixes {
+		objects, err := h.storage.List(prefix + deletion.ProjectID + "/")
+		if err != nil {
+			return err
+		}
+		for _, object := range objects {
+			if err := h.storage.Delete(object.Key); err != nil {
+				return err
+			}
+		}
+	}
+
+	ossKeys, err := h.db.DeleteProject(deletion.ProjectID)
+	if err != nil {
+		return err
+	}
+	for _, ossKey := range ossKeys {
+		h.deleteUnreferencedObject(ossKey)
+	}
+	return nil
+}
+
+// RestoreProject 网页端从回收站恢复项目
+func (h *Handler) RestoreProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := h.db.RestoreProject(r.FormValue("id"))
+	if errors.Is(err, database.ErrNotInTrash) {
+		http.Redirect(w, r, "/?error=项目不在回收站中或已开始清理", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to restore project: %v", err)
+		http.Redirect(w, r, "/?error=恢复项目失败", http.StatusSeeOther)
+		return
+	}
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// PurgeProject 网页端立即清理回收站中的项目
+func (h *Handler) PurgeProject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	err := h.db.PurgeProjectNow(r.FormValue("id"))
+	if errors.Is(err, database.ErrNotInTrash) {
+		http.Redirect(w, r, "/?error=项目不在回收站中或已开始清理", http.StatusSeeOther)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to schedule purge: %v", err)
+		http.Redirect(w, r, "/?error=清理项目失败", http.StatusSeeOther)
+		return
+	}
+	h.wakePurgeJob()
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
@@ -0,0 +1,389 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"sync"
+
+	"chchma.com/cloudlite-sync/internal/compress"
+	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/utils"
+	"chchma.com/cloudlite-sync/internal/validator"
+)
+
+// parseUploadForm 限制请求体大小并解析 multipart 表单，超出内存上限的文件内容会落盘到临时文件
+func (h *Handler) parseUploadForm(w http.ResponseWriter, r *http.Request) error {
+	r.Body = http.MaxBytesReader(w, r.Body, h.config.Upload.MaxSizeMB<<20)
+	return r.ParseMultipartForm(h.config.Upload.MemoryMB << 20)
+}
+
+// maxUploadFieldsBytes 流式读取上传表单时，文件之前的普通字段的总大小上限
+const maxUploadFieldsBytes = 1 << 20
+
+// errUploadFieldsTooLarge 上传表单中的普通字段超出大小上限
+var errUploadFieldsTooLarge = errors.New("form fields too large")
+
+// readUploadFields 限制请求体大小，流式读取 multipart 表单中 fileField 文件之前的普通字段，
+// 读到文件时停止并返回文件部分，由调用方在验证凭证后再读取文件内容；表单中没有该文件时返回的文件为 nil。
+// 字段与 URL 查询参数合并后存入 r.Form，之后可以照常使用 r.FormValue
+func (h *Handler) readUploadFields(w http.ResponseWriter, r *http.Request, fileField string) (*multipart.Part, error) {
+	r.Body = http.MaxBytesReader(w, r.Body, h.config.Upload.MaxSizeMB<<20)
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+
+	form := r.URL.Query()
+	remaining := int64(maxUploadFieldsBytes)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			r.Form = form
+			return nil, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == fileField {
+			r.Form = form
+			return part, nil
+		}
+		if part.FileName() != "" {
+			// 忽略其他文件
+			part.Close()
+			continue
+		}
+		value, err := io.ReadAll(io.LimitReader(part, remaining+1))
+		part.Close()
+		if err != nil {
+			return nil, err
+		}
+		remaining -= int64(len(value))
+		if remaining < 0 {
+			return nil, errUploadFieldsTooLarge
+		}
+		form.Add(part.FormName(), string(value))
+	}
+}
+
+// isTooLarge 判断错误是否由请求体超出大小上限引起
+func isTooLarge(err error) bool {
+	var maxBytesErr *http.MaxBytesError
+	return errors.As(err, &maxBytesErr)
+}
+
+// storeVersion 将上传内容写入临时文件并计算哈希，校验通过后写入存储并创建新的最新版本记录。
+// 如果内容与项目中已有版本相同，则不再写入存储，直接返回已有版本；
+// 校验失败时返回 *validator.Error，标签已被其他版本占用时返回 database.ErrLabelExists。
+func (h *Handler) storeVersion(projectID, fileName, description string, meta versionMeta, src io.Reader) (created, existing *models.DatabaseVersion, err error) {
+	project, err := h.db.GetProject(projectID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if project == nil {
+		return nil, nil, fmt.Errorf("project %s not found", projectID)
+	}
+
+	tmp, err := os.CreateTemp("", "cloudlite-upload-*.db")
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hr := utils.NewHashReader(src)
+	if _, err := io.Copy(tmp, hr); err != nil {
+		return nil, nil, fmt.Errorf("failed to receive file: %w", err)
+	}
+	fileHash, fileSHA256 := hr.Hash(), hr.SHA256()
+
+	// 检查文件是否已存在
+	existing, err = h.db.GetVersionByContent(projectID, fileHash, fileSHA256)
+	if err != nil {
+		return nil, nil, err
+	}
+	if existing != nil {
+		return nil, existing, nil
+	}
+	if meta.Label != "" {
+		labeled, err := h.db.GetVersionByLabel(projectID, meta.Label)
+		if err != nil {
+			return nil, nil, err
+		}
+		if labeled != nil {
+			return nil, nil, database.ErrLabelExists
+		}
+	}
+
+	// 校验通过前不写入存储，避免损坏的文件成为最新版本
+	if err := validator.Validate(tmp.Name(), validator.Options{
+		Check:          h.config.Upload.IntegrityCheck,
+		RequiredTables: utils.SplitList(project.RequiredTables),
+	}); err != nil {
+		return nil, nil, err
+	}
+
+	schemaVersion, err := validator.UserVersion(tmp.Name())
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dbVersion := &models.DatabaseVersion{
+		ID:            utils.GenerateUUID(),
+		ProjectID:     projectID,
+		Version:       utils.GenerateVersion(),
+		FileHash:      fileHash,
+		SHA256:        fileSHA256,
+		FileName:      fileName,
+		FileSize:      hr.Size(),
+		Description:   description,
+		IsLatest:      true, // 新上传的版本设为最新
+		Label:         meta.Label,
+		Metadata:      meta.Metadata,
+		SchemaVersion: schemaVersion,
+	}
+	if err := h.writeVersionObject(dbVersion, tmp); err != nil {
+		return nil, nil, fmt.Errorf("failed to upload file to storage: %w", err)
+	}
+	if err := h.db.CreateDatabaseVersion(dbVersion); err != nil {
+		// 如果数据库操作失败，释放写入时取得的引用，文件不再被其他版本引用时删除
+		h.releaseObject(dbVersion.OSSKey)
+		return nil, nil, err
+	}
+
+	return dbVersion, nil, nil
+}
+
+// writeVersionObject 将版本文件写入存储，并填充 dbVersion 的 OSSKey、StoredSize、Compression 和 KeyID。
+// 未启用加密时按内容 SHA-256 存储为共享对象并取得一个引用，内容相同的对象已存在时直接引用而不重复写入，
+// 不同项目上传的相同文件只存储一份；启用加密时各项目使用各自的数据密钥，按版本单独存储
+func (h *Handler) writeVersionObject(dbVersion *models.DatabaseVersion, tmp *os.File) error {
+	if h.keys.Enabled() {
+		dbVersion.OSSKey = utils.GenerateOSSKey(dbVersion.ProjectID, dbVersion.ID, dbVersion.FileName)
+		return h.putVersionObject(dbVersion, tmp, h.config.Storage.Compression, false)
+	}
+
+	dbVersion.OSSKey = utils.GenerateBlobKey(dbVersion.SHA256)
+	// 取得引用和删除不再被引用的对象按存储键互斥：删除方确认没有引用后才删除，取得引用后对象不会再被删除
+	h.blobLocks.Lock(dbVersion.OSSKey)
+	retained, err := h.acquireBlobObject(dbVersion, tmp)
+	h.blobLocks.Unlock(dbVersion.OSSKey)
+	if err != nil && retained {
+		h.releaseObject(dbVersion.OSSKey)
+	}
+	return err
+}
+
+// acquireBlobObject 取得共享对象的引用并确保对象存在于存储中，调用方需持有该存储键的锁。返回是否已取得引用
+func (h *Handler) acquireBlobObject(dbVersion *models.DatabaseVersion, tmp *os.File) (bool, error) {
+	blob, err := h.db.RetainBlob(dbVersion.SHA256)
+	if err != nil {
+		return false, err
+	}
+	if blob == nil {
+		if err := h.putVersionObject(dbVersion, tmp, h.config.Storage.Compression, false); err != nil {
+			return false, err
+		}
+		return true, h.db.AcquireBlob(dbVersion)
+	}
+
+	dbVersion.OSSKey, dbVersion.StoredSize, dbVersion.Compression = blob.OSSKey, blob.StoredSize, blob.Compression
+	exists, err := h.storage.Exists(blob.OSSKey)
+	if err == nil && !exists {
+		// 记录存在但存储中的对象丢失，按原来的压缩方式重新写入，引用它的其他版本随之恢复
+		log.Printf("Blob %s is missing from storage, rewriting it", blob.OSSKey)
+		err = h.putVersionObject(dbVersion, tmp, blob.Compression, true)
+	}
+	return true, err
+}
+
+// putVersionObject 按 algorithm 压缩后写入存储，压缩后没有变小时按原样存储（force 时总是压缩，用于恢复共享对象），
+// 启用加密时在压缩之后用项目数据密钥加密
+func (h *Handler) putVersionObject(dbVersion *models.DatabaseVersion, tmp *os.File, algorithm string, force bool) error {
+	var object io.ReadSeeker = tmp
+	dbVersion.StoredSize, dbVersion.Compression = dbVersion.FileSize, compress.None
+	if algorithm != compress.None {
+		compressed, compressedSize, err := compressFile(tmp, algorithm)
+		if err != nil {
+			return err
+		}
+		defer compressed.Close()
+		if compressedSize < dbVersion.StoredSize || force {
+			object, dbVersion.StoredSize, dbVersion.Compression = compressed, compressedSize, algorithm
+		}
+	}
+	if h.keys.Enabled() {
+		keyID, dataKey, err := h.projectDataKey(dbVersion.ProjectID)
+		if err != nil {
+			return err
+		}
+		encrypted, encryptedSize, err := encryptFile(object, dataKey)
+		if err != nil {
+			return err
+		}
+		defer encrypted.Close()
+		object, dbVersion.StoredSize, dbVersion.KeyID = encrypted, encryptedSize, keyID
+	}
+	return h.putVerified(dbVersion.OSSKey, object)
+}
+
+// releaseObject 释放版本对存储对象的引用，对象不再被引用时删除
+func (h *Handler) releaseObject(ossKey string) {
+	unreferenced, err := h.db.ReleaseBlob(ossKey)
+	if err != nil {
+		log.Printf("Failed to release object %s: %v", ossKey, err)
+		return
+	}
+	if unreferenced {
+		h.deleteUnreferencedObject(ossKey)
+	}
+}
+
+// deleteUnreferencedObject 删除已释放最后一个引用的存储对象。相同内容的新上传可能在此期间重新引用了共享对象，
+// 持有存储键的锁再次确认后删除
+func (h *Handler) deleteUnreferencedObject(ossKey string) {
+	h.blobLocks.Lock(ossKey)
+	defer h.blobLocks.Unlock(ossKey)
+
+	referenced, err := h.db.IsBlobReferenced(ossKey)
+	if err != nil {
+		log.Printf("Failed to delete object %s: %v", ossKey, err)
+		return
+	}
+	if referenced {
+		return
+	}
+	if err := h.storage.Delete(ossKey); err != nil {
+		log.Printf("Failed to delete object %s: %v", ossKey, err)
+	}
+}
+
+// putVerified 写入存储后读回对象，校验大小和 SHA-256 与写入的内容一致
+func (h *Handler) putVerified(key string, object io.ReadSeeker) error {
+	if _, err := object.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	digest := sha256.New()
+	size, err := io.Copy(digest, object)
+	if err != nil {
+		return err
+	}
+	if _, err := object.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if err := h.storage.Put(key, object); err != nil {
+		return err
+	}
+	return h.verifyStored(key, size, hex.EncodeToString(digest.Sum(nil)))
+}
+
+// verifyStored 读回存储中的对象并与写入时的大小和 SHA-256 比较，不一致时删除对象并返回错误
+func (h *Handler) verifyStored(key string, size int64, sha256Hash string) error {
+	stored, err := h.storage.Get(key)
+	if err != nil {
+		return fmt.Errorf("failed to read back object %s: %w", key, err)
+	}
+	hr := utils.NewHashReader(stored)
+	_, err = io.Copy(io.Discard, hr)
+	stored.Close()
+	if err == nil && (hr.Size() != size || hr.SHA256() != sha256Hash) {
+		err = fmt.Errorf("stored object %s does not match the written content (size %d, sha256 %s; expected size %d, sha256 %s)",
+			key, hr.Size(), hr.SHA256(), size, sha256Hash)
+	}
+	if err != nil {
+		if err := h.storage.Delete(key); err != nil {
+			log.Printf("Failed to delete object %s: %v", key, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// compressFile 将文件内容压缩到新的临时文件（已删除目录项，关闭后自动释放），返回压缩后的大小
+func compressFile(src *os.File, algorithm string) (*os.File, int64, error) {
+	if _, err := src.Seek(0, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	compressed, err := os.CreateTemp("", "cloudlite-compressed-*")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temp file: %w", err)
+	}
+	os.Remove(compressed.Name())
+	if err := compress.Compress(compressed, src, algorithm); err != nil {
+		compressed.Close()
+		return nil, 0, fmt.Errorf("failed to compress file: %w", err)
+	}
+	size, err := compressed.Seek(0, io.SeekCurrent)
+	if err != nil {
+		compressed.Close()
+		return nil, 0, err
+	}
+	return compressed, size, nil
+}
+
+// validationError 判断错误是否为上传文件校验失败
+func validationError(err error) *validator.Error {
+	var validationErr *validator.Error
+	if errors.As(err, &validationErr) {
+		return validationErr
+	}
+	return nil
+}
+
+// writeValidationError 以 422 返回结构化的校验错误
+func writeValidationError(w http.ResponseWriter, validationErr *validator.Error) {
+	writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{
+		"success": false,
+		"message": "Database validation failed",
+		"error":   validationErr,
+	})
+}
+
+// keyLocks 按键加锁，零值可用，键不再被使用时释放对应的锁
+type keyLocks struct {
+	mutex sync.Mutex
+	locks map[string]*keyLock
+}
+
+type keyLock struct {
+	sync.Mutex
+	holders int // 持有或等待该锁的调用方数
+}
+
+// Lock 锁定 key
+func (l *keyLocks) Lock(key string) {
+	l.mutex.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*keyLock)
+	}
+	lock, ok := l.locks[key]
+	if !ok {
+		lock = &keyLock{}
+		l.locks[key] = lock
+	}
+	lock.holders++
+	l.mutex.Unlock()
+
+	lock.Lock()
+}
+
+// Unlock 解锁 key
+func (l *keyLocks) Unlock(key string) {
+	l.mutex.Lock()
+	lock := l.locks[key]
+	lock.holders--
+	if lock.holders == 0 {
+		delete(l.locks, key)
+	}
+	l.mutex.Unlock()
+
+	lock.Unlock()
+}
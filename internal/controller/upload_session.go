@@ -0,0 +1,402 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/database"
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/storage"
+	"chchma.com/cloudlite-sync/internal/utils"
+	"github.com/go-chi/chi/v5"
+)
+
+// maxUploadParts 单个会话允许的最大分片数
+const maxUploadParts = 10000
+
+// uploadExpiryCheckInterval 后台任务检查过期上传会话的间隔
+const uploadExpiryCheckInterval = 10 * time.Minute
+
+// ApiCreateUpload 创建分片上传会话
+func (h *Handler) ApiCreateUpload(w http.ResponseWriter, r *http.Request) {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.FormValue("token"), projectID, models.ScopeWrite)
+	if credential == nil {
+		return
+	}
+
+	fileName := filepath.Base(r.FormValue("file_name"))
+	if fileName == "." || fileName == "/" {
+		http.Error(w, "File name is required", http.StatusBadRequest)
+		return
+	}
+	meta, err := parseVersionMeta(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	session := &models.UploadSession{
+		ID:           utils.GenerateUUID(),
+		ProjectID:    projectID,
+		CredentialID: credential.ID,
+		FileName:     fileName,
+		Description:  r.FormValue("description"),
+		Label:        meta.Label,
+		Metadata:     meta.Metadata,
+		Status:       models.UploadStatusPending,
+	}
+	if err := h.db.CreateUploadSession(session); err != nil {
+		http.Error(w, "Failed to create upload session", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"upload":  session,
+	})
+}
+
+// ApiGetUpload 查询分片上传会话及已上传的分片，用于断点续传
+func (h *Handler) ApiGetUpload(w http.ResponseWriter, r *http.Request) {
+	session := h.loadUploadSession(w, r)
+	if session == nil {
+		return
+	}
+
+	parts, err := h.db.ListUploadParts(session.ID)
+	if err != nil {
+		http.Error(w, "Failed to get upload parts", http.StatusInternalServerError)
+		return
+	}
+	session.Parts = parts
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"upload":  session,
+	})
+}
+
+// ApiUploadPart 上传单个分片，请求体即分片内容，同一分片号可重复上传
+func (h *Handler) ApiUploadPart(w http.ResponseWriter, r *http.Request) {
+	session := h.loadUploadSession(w, r)
+	if session == nil {
+		return
+	}
+	if session.Status != models.UploadStatusPending {
+		http.Error(w, "Upload session is "+session.Status, http.StatusConflict)
+		return
+	}
+
+	partNumber, err := strconv.Atoi(chi.URLParam(r, "partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxUploadParts {
+		http.Error(w, fmt.Sprintf("Part number must be between 1 and %d", maxUploadParts), http.StatusBadRequest)
+		return
+	}
+
+	// 分片大小不能超过会话剩余的额度，保存时在事务中再次按总大小检查
+	parts, err := h.db.ListUploadParts(session.ID)
+	if err != nil {
+		http.Error(w, "Failed to get upload parts", http.StatusInternalServerError)
+		return
+	}
+	maxTotal := h.config.Upload.MaxSizeMB << 20
+	remaining := maxTotal
+	for _, part := range parts {
+		if part.PartNumber != partNumber {
+			remaining -= part.Size
+		}
+	}
+	if remaining <= 0 {
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	ossKey := utils.GenerateUploadPartKey(session.ProjectID, session.ID, partNumber)
+	hr := utils.NewHashReader(http.MaxBytesReader(w, r.Body, remaining))
+	if err := h.storage.Put(ossKey, hr); err != nil {
+		if isTooLarge(err) {
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+			return
+		}
+		log.Printf("Failed to store upload part %s: %v", ossKey, err)
+		http.Error(w, "Failed to store upload part", http.StatusInternalServerError)
+		return
+	}
+	if err := h.verifyStored(ossKey, hr.Size(), hr.SHA256()); err != nil {
+		log.Printf("Failed to verify upload part %s: %v", ossKey, err)
+		http.Error(w, "Failed to store upload part", http.StatusInternalServerError)
+		return
+	}
+
+	part := &models.UploadPart{
+		SessionID:  session.ID,
+		PartNumber: partNumber,
+		Size:       hr.Size(),
+		PartHash:   hr.Hash(),
+		OSSKey:     ossKey,
+	}
+	if err := h.db.SaveUploadPart(part, maxTotal); err != nil {
+		switch {
+		case errors.Is(err, database.ErrUploadNotPending):
+			// 会话已在上传期间结束，分片不再有会话记录引用
+			h.deleteUploadParts([]*models.UploadPart{part})
+			http.Error(w, "Upload session is no longer pending", http.StatusConflict)
+		case errors.Is(err, database.ErrUploadTooLarge):
+			h.deleteUploadParts([]*models.UploadPart{part})
+			http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		default:
+			http.Error(w, "Failed to save upload part", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"part":    part,
+	})
+}
+
+// ApiCompleteUpload 合并全部分片并创建数据库版本，与单次上传使用相同的去重逻辑
+func (h *Handler) ApiCompleteUpload(w http.ResponseWriter, r *http.Request) {
+	session := h.loadUploadSession(w, r)
+	if session == nil {
+		return
+	}
+
+	switch session.Status {
+	case models.UploadStatusCompleted:
+		// 重复提交时直接返回之前的结果，便于客户端在网络中断后重试
+		dbVersion, err := h.db.GetDatabaseVersion(session.VersionID)
+		if err != nil {
+			http.Error(w, "Failed to get version", http.StatusInternalServerError)
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{
+			"success": true,
+			"message": "Upload already completed",
+			"version": dbVersion,
+		})
+		return
+	case models.UploadStatusAborted, models.UploadStatusExpired:
+		http.Error(w, "Upload session is "+session.Status, http.StatusConflict)
+		return
+	}
+
+	parts, err := h.db.ListUploadParts(session.ID)
+	if err != nil {
+		http.Error(w, "Failed to get upload parts", http.StatusInternalServerError)
+		return
+	}
+	if len(parts) == 0 {
+		http.Error(w, "No parts uploaded", http.StatusBadRequest)
+		return
+	}
+
+	// 分片号必须从1开始连续
+	var totalSize int64
+	for i, part := range parts {
+		if part.PartNumber != i+1 {
+			http.Error(w, fmt.Sprintf("Missing part %d", i+1), http.StatusBadRequest)
+			return
+		}
+		totalSize += part.Size
+	}
+	if totalSize > h.config.Upload.MaxSizeMB<<20 {
+		http.Error(w, "File too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+
+	src := &partsReader{storage: h.storage, parts: parts}
+	meta := versionMeta{Label: session.Label, Metadata: session.Metadata}
+	dbVersion, existingVersion, err := h.storeVersion(session.ProjectID, session.FileName, session.Description, meta, src)
+	src.Close()
+	if validationErr := validationError(err); validationErr != nil {
+		// 会话保持未完成状态，客户端可重新上传有问题的分片后再次合并
+		writeValidationError(w, validationErr)
+		return
+	}
+	if isLabelConflict(err) {
+		h.writeLabelConflict(w, session.ProjectID, session.Label)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to store database version: %v", err)
+		http.Error(w, "Failed to store database file", http.StatusInternalServerError)
+		return
+	}
+
+	finished := dbVersion
+	if finished == nil {
+		finished = existingVersion
+	}
+	// 先结束会话再删除分片，更新失败时会话和分片保持不变，客户端可以重试（内容相同时返回已创建的版本）
+	if err := h.db.FinishUploadSession(session.ID, models.UploadStatusCompleted, finished.ID); err != nil {
+		if errors.Is(err, database.ErrUploadNotPending) {
+			// 会话在合并期间被放弃、过期或由另一个请求完成，分片由结束会话的一方删除
+			http.Error(w, "Upload session is no longer pending", http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to finish upload session %s: %v", session.ID, err)
+		http.Error(w, "Failed to finish upload session", http.StatusInternalServerError)
+		return
+	}
+	h.deleteUploadParts(parts)
+
+	if existingVersion != nil {
+		writeJSON(w, http.StatusConflict, map[string]interface{}{
+			"success": false,
+			"message": "File already exists",
+			"version": existingVersion,
+		})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Database uploaded successfully",
+		"version": dbVersion,
+	})
+}
+
+// ApiAbortUpload 放弃分片上传会话并删除已上传的分片
+func (h *Handler) ApiAbortUpload(w http.ResponseWriter, r *http.Request) {
+	session := h.loadUploadSession(w, r)
+	if session == nil {
+		return
+	}
+	if session.Status != models.UploadStatusPending {
+		http.Error(w, "Upload session is "+session.Status, http.StatusConflict)
+		return
+	}
+
+	parts, err := h.db.ListUploadParts(session.ID)
+	if err != nil {
+		http.Error(w, "Failed to get upload parts", http.StatusInternalServerError)
+		return
+	}
+	if err := h.db.FinishUploadSession(session.ID, models.UploadStatusAborted, ""); err != nil {
+		if errors.Is(err, database.ErrUploadNotPending) {
+			http.Error(w, "Upload session is no longer pending", http.StatusConflict)
+			return
+		}
+		http.Error(w, "Failed to abort upload session", http.StatusInternalServerError)
+		return
+	}
+	h.deleteUploadParts(parts)
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"success": true,
+		"message": "Upload aborted",
+	})
+}
+
+// startUploadExpiryJob 定期清理过期的分片上传会话
+func (h *Handler) startUploadExpiryJob() {
+	ticker := time.NewTicker(uploadExpiryCheckInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		h.expireUploadSessions()
+	}
+}
+
+// expireUploadSessions 将超过有效期未完成的上传会话标记为过期，并删除已上传的分片
+func (h *Handler) expireUploadSessions() {
+	if h.config.Upload.SessionTTLHours <= 0 {
+		return
+	}
+	before := time.Now().Add(-time.Duration(h.config.Upload.SessionTTLHours) * time.Hour)
+	ids, err := h.db.ListStaleUploadSessions(before)
+	if err != nil {
+		log.Printf("Upload: failed to list stale sessions: %v", err)
+		return
+	}
+	for _, id := range ids {
+		parts, err := h.db.ExpireUploadSession(id, before)
+		if err != nil {
+			log.Printf("Upload: failed to expire session %s: %v", id, err)
+			continue
+		}
+		h.deleteUploadParts(parts)
+		if parts != nil {
+			log.Printf("Upload: expired session %s, deleted %d parts", id, len(parts))
+		}
+	}
+}
+
+// loadUploadSession 校验凭证并获取属于该凭证的上传会话，失败时写入错误响应并返回 nil
+func (h *Handler) loadUploadSession(w http.ResponseWriter, r *http.Request) *models.UploadSession {
+	projectID := chi.URLParam(r, "projectID")
+	credential := h.authorizeAPI(w, r, r.URL.Query().Get("token"), projectID, models.ScopeWrite)
+	if credential == nil {
+		return nil
+	}
+
+	session, err := h.db.GetUploadSession(chi.URLParam(r, "uploadID"))
+	if err != nil {
+		http.Error(w, "Failed to get upload session", http.StatusInternalServerError)
+		return nil
+	}
+	if session == nil || session.ProjectID != projectID || session.CredentialID != credential.ID {
+		http.Error(w, "Upload session not found", http.StatusNotFound)
+		return nil
+	}
+	return session
+}
+
+// deleteUploadParts 删除分片的临时对象
+func (h *Handler) deleteUploadParts(parts []*models.UploadPart) {
+	for _, part := range parts {
+		if err := h.storage.Delete(part.OSSKey); err != nil {
+			log.Printf("Failed to delete upload part %s: %v", part.OSSKey, err)
+		}
+	}
+}
+
+// partsReader 按顺序读取各分片对象，拼接成完整文件
+type partsReader struct {
+	storage storage.Storage
+	parts   []*models.UploadPart
+	current io.ReadCloser
+}
+
+func (pr *partsReader) Read(p []byte) (int, error) {
+	for {
+		if pr.current == nil {
+			if len(pr.parts) == 0 {
+				return 0, io.EOF
+			}
+			object, err := pr.storage.Get(pr.parts[0].OSSKey)
+			if err != nil {
+				return 0, fmt.Errorf("failed to open part %d: %w", pr.parts[0].PartNumber, err)
+			}
+			pr.current = object
+			pr.parts = pr.parts[1:]
+		}
+
+		n, err := pr.current.Read(p)
+		if err == io.EOF {
+			pr.current.Close()
+			pr.current = nil
+			if n > 0 {
+				return n, nil
+			}
+			continue
+		}
+		return n, err
+	}
+}
+
+func (pr *partsReader) Close() error {
+	if pr.current != nil {
+		return pr.current.Close()
+	}
+	return nil
+}
@@ -0,0 +1,118 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+)
+
+// blobColumns 查询共享对象时的列，顺序与 scanBlob 一致
+const blobColumns = `sha256, oss_key, file_size, stored_size, compression, ref_count, created_at, updated_at`
+
+// scanBlob 扫描一行共享对象记录
+func scanBlob(row rowScanner) (*models.Blob, error) {
+	blob := &models.Blob{}
+	err := row.Scan(
+		&blob.SHA256,
+		&blob.OSSKey,
+		&blob.FileSize,
+		&blob.StoredSize,
+		&blob.Compression,
+		&blob.RefCount,
+		&blob.CreatedAt,
+		&blob.UpdatedAt,
+	)
+	return blob, err
+}
+
+// GetBlob 按内容 SHA-256 获取共享对象
+func (db *DB) GetBlob(sha256Hash string) (*models.Blob, error) {
+	blob, err := scanBlob(db.QueryRow(`SELECT `+blobColumns+` FROM blobs WHERE sha256 = ?`, sha256Hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get blob: %w", err)
+	}
+	return blob, nil
+}
+
+// RetainBlob 为即将创建的版本预先增加已有共享对象的引用计数，返回对象记录，记录不存在时返回 nil。
+// 持有引用期间其他版本被删除时不会删除该对象，版本创建失败时需调用 ReleaseBlob 释放
+func (db *DB) RetainBlob(sha256Hash string) (*models.Blob, error) {
+	blob, err := scanBlob(db.QueryRow(`UPDATE blobs SET ref_count = ref_count + 1, updated_at = ?
+			  WHERE sha256 = ? RETURNING `+blobColumns, time.Now(), sha256Hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to retain blob: %w", err)
+	}
+	return blob, nil
+}
+
+// AcquireBlob 为新写入共享对象的版本创建对象记录并取得一个引用。并发上传相同内容时记录可能已由另一方创建，
+// 此时只增加引用计数，并以记录中的存储大小和压缩方式填充 version
+func (db *DB) AcquireBlob(version *models.DatabaseVersion) error {
+	now := time.Now()
+	err := db.QueryRow(`INSERT INTO blobs (sha256, oss_key, file_size, stored_size, compression, ref_count, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, 1, ?, ?)
+			  ON CONFLICT(sha256) DO UPDATE SET ref_count = ref_count + 1, updated_at = excluded.updated_at
+			  RETURNING stored_size, compression`,
+		version.SHA256, version.OSSKey, version.FileSize, version.StoredSize, version.Compression, now, now).
+		Scan(&version.StoredSize, &version.Compression)
+	if err != nil {
+		return fmt.Errorf("failed to acquire blob: %w", err)
+	}
+	return nil
+}
+
+// ReleaseBlob 释放 RetainBlob 或 AcquireBlob 取得的引用，返回存储对象是否已不再被引用
+func (db *DB) ReleaseBlob(ossKey string) (bool, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	unreferenced, err := releaseBlobTx(tx, ossKey)
+	if err != nil {
+		return false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return unreferenced, nil
+}
+
+// IsBlobReferenced 判断存储键是否为仍被引用的共享对象
+func (db *DB) IsBlobReferenced(ossKey string) (bool, error) {
+	var count int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM blobs WHERE oss_key = ?`, ossKey).Scan(&count); err != nil {
+		return false, fmt.Errorf("failed to check blob: %w", err)
+	}
+	return count > 0, nil
+}
+
+// releaseBlobTx 在删除版本时释放其存储对象的引用，返回存储对象是否已不再被任何版本引用。
+// 共享对象的引用计数降为 0 时删除记录；不是共享对象的存储键只属于该版本，总是返回 true
+func releaseBlobTx(tx *sql.Tx, ossKey string) (bool, error) {
+	var refCount int
+	err := tx.QueryRow(`UPDATE blobs SET ref_count = ref_count - 1, updated_at = ?
+			  WHERE oss_key = ? RETURNING ref_count`, time.Now(), ossKey).Scan(&refCount)
+	if err == sql.ErrNoRows {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to release blob: %w", err)
+	}
+	if refCount > 0 {
+		return false, nil
+	}
+	if _, err := tx.Exec(`DELETE FROM blobs WHERE oss_key = ?`, ossKey); err != nil {
+		return false, fmt.Errorf("failed to delete blob: %w", err)
+	}
+	return true, nil
+}
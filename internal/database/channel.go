@@ -0,0 +1,268 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+	"chchma.com/cloudlite-sync/internal/utils"
+)
+
+var (
+	// ErrChannelNotFound 渠道不存在
+	ErrChannelNotFound = errors.New("channel not found")
+	// ErrNoPreviousVersion 渠道没有可回滚的上一个版本
+	ErrNoPreviousVersion = errors.New("channel has no previous version")
+)
+
+const channelColumns = `id, project_id, name, version_id, updated_by, created_at, updated_at`
+
+func scanChannel(row rowScanner) (*models.Channel, error) {
+	channel := &models.Channel{}
+	err := row.Scan(
+		&channel.ID,
+		&channel.ProjectID,
+		&channel.Name,
+		&channel.VersionID,
+		&channel.UpdatedBy,
+		&channel.CreatedAt,
+		&channel.UpdatedAt,
+	)
+	return channel, err
+}
+
+// GetChannel 获取项目的渠道
+func (db *DB) GetChannel(projectID, name string) (*models.Channel, error) {
+	query := `SELECT ` + channelColumns + ` FROM channels WHERE project_id = ? AND name = ?`
+
+	channel, err := scanChannel(db.QueryRow(query, projectID, name))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get channel: %w", err)
+	}
+	return channel, nil
+}
+
+// ListChannels 获取项目的全部渠道
+func (db *DB) ListChannels(projectID string) ([]*models.Channel, error) {
+	query := `SELECT ` + channelColumns + ` FROM channels WHERE project_id = ? ORDER BY name`
+
+	rows, err := db.Query(query, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query channels: %w", err)
+	}
+	defer rows.Close()
+
+	var channels []*models.Channel
+	for rows.Next() {
+		channel, err := scanChannel(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan channel: %w", err)
+		}
+		channels = append(channels, channel)
+	}
+	return channels, rows.Err()
+}
+
+// ListVersionChannels 获取指向某个版本的渠道名称
+func (db *DB) ListVersionChannels(versionID string) ([]string, error) {
+	rows, err := db.Query(`SELECT name FROM channels WHERE version_id = ? ORDER BY name`, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query channels: %w", err)
+	}
+	defer rows.Close()
+
+	var names []string
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to scan channel: %w", err)
+		}
+		names = append(names, name)
+	}
+	return names, rows.Err()
+}
+
+// SetChannel 将渠道指向指定版本，渠道不存在时创建。更新渠道和写入变更记录在同一事务中完成
+func (db *DB) SetChannel(projectID, name, versionID, movedBy string) (*models.ChannelMove, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	move, err := setChannelTx(tx, projectID, name, versionID, movedBy, models.ChannelActionMove)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return move, nil
+}
+
+// RollbackChannel 将渠道指回当前版本之前指向的版本
+func (db *DB) RollbackChannel(projectID, name, movedBy string) (*models.ChannelMove, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var currentID string
+	err = tx.QueryRow(`SELECT version_id FROM channels WHERE project_id = ? AND name = ?`, projectID, name).Scan(&currentID)
+	if err == sql.ErrNoRows {
+		return nil, ErrChannelNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get channel: %w", err)
+	}
+	previousID, err := previousVersionTx(tx, projectID, name, currentID)
+	if err != nil {
+		return nil, err
+	}
+
+	move, err := setChannelTx(tx, projectID, name, previousID, movedBy, models.ChannelActionRollback)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return move, nil
+}
+
+func setChannelTx(tx *sql.Tx, projectID, name, versionID, movedBy, action string) (*models.ChannelMove, error) {
+	now := time.Now()
+	move := &models.ChannelMove{
+		ID:          utils.GenerateUUID(),
+		ProjectID:   projectID,
+		ChannelName: name,
+		Action:      action,
+		ToVersionID: versionID,
+		MovedBy:     movedBy,
+		CreatedAt:   now,
+	}
+
+	err := tx.QueryRow(`SELECT version_id FROM channels WHERE project_id = ? AND name = ?`, projectID, name).Scan(&move.FromVersionID)
+	switch {
+	case err == sql.ErrNoRows:
+		move.Action = models.ChannelActionCreate
+		_, err = tx.Exec(`INSERT INTO channels (`+channelColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			utils.GenerateUUID(), projectID, name, versionID, movedBy, now, now)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create channel: %w", err)
+		}
+	case err != nil:
+		return nil, fmt.Errorf("failed to get channel: %w", err)
+	default:
+		_, err = tx.Exec(`UPDATE channels SET version_id = ?, updated_by = ?, updated_at = ? WHERE project_id = ? AND name = ?`,
+			versionID, movedBy, now, projectID, name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to move channel: %w", err)
+		}
+	}
+
+	if err := recordMoveTx(tx, move); err != nil {
+		return nil, err
+	}
+	return move, nil
+}
+
+// previousVersionTx 查找将渠道指向 currentID 的那次变更之前的版本，该版本不存在时返回 ErrNoPreviousVersion。
+// 回滚产生的变更不参与查找，因此连续回滚会沿历史逐步后退，而不是在两个版本之间来回切换
+func previousVersionTx(tx *sql.Tx, projectID, channel, currentID string) (string, error) {
+	var previousID string
+	err := tx.QueryRow(`SELECT from_version_id FROM channel_moves
+						WHERE project_id = ? AND channel_name = ? AND to_version_id = ? AND action != ?
+						ORDER BY created_at DESC LIMIT 1`, projectID, channel, currentID, models.ChannelActionRollback).Scan(&previousID)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to get channel history: %w", err)
+	}
+	if previousID == "" {
+		return "", ErrNoPreviousVersion
+	}
+	// 上一个版本已被删除时无法回滚
+	var exists bool
+	err = tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM database_versions WHERE id = ?)`, previousID).Scan(&exists)
+	if err != nil {
+		return "", fmt.Errorf("failed to get version: %w", err)
+	}
+	if !exists {
+		return "", ErrNoPreviousVersion
+	}
+	return previousID, nil
+}
+
+// recordMoveTx 写入一条渠道变更记录
+func recordMoveTx(tx *sql.Tx, move *models.ChannelMove) error {
+	if move.ID == "" {
+		move.ID = utils.GenerateUUID()
+	}
+	if move.CreatedAt.IsZero() {
+		move.CreatedAt = time.Now()
+	}
+	_, err := tx.Exec(`INSERT INTO channel_moves (id, project_id, channel_name, action, from_version_id, to_version_id, moved_by, created_at)
+					   VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		move.ID, move.ProjectID, move.ChannelName, move.Action, move.FromVersionID, move.ToVersionID, move.MovedBy, move.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to record channel move: %w", err)
+	}
+	return nil
+}
+
+// DeleteChannel 删除渠道，保留变更记录
+func (db *DB) DeleteChannel(projectID, name string) error {
+	_, err := db.Exec(`DELETE FROM channels WHERE project_id = ? AND name = ?`, projectID, name)
+	if err != nil {
+		return fmt.Errorf("failed to delete channel: %w", err)
+	}
+	return nil
+}
+
+// ListChannelMoves 获取项目最近的渠道变更记录，channel 为空时返回全部渠道的记录
+func (db *DB) ListChannelMoves(projectID, channel string, limit int) ([]*models.ChannelMove, error) {
+	query := `SELECT m.id, m.project_id, m.channel_name, m.action, m.from_version_id, m.to_version_id, m.moved_by, m.created_at,
+			  COALESCE(f.version, ''), COALESCE(f.file_hash, ''), COALESCE(t.version, ''), COALESCE(t.file_hash, '')
+			  FROM channel_moves m
+			  LEFT JOIN database_versions f ON f.id = m.from_version_id
+			  LEFT JOIN database_versions t ON t.id = m.to_version_id
+			  WHERE m.project_id = ? AND (? = '' OR m.channel_name = ?)
+			  ORDER BY m.created_at DESC LIMIT ?`
+
+	rows, err := db.Query(query, projectID, channel, channel, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query channel moves: %w", err)
+	}
+	defer rows.Close()
+
+	var moves []*models.ChannelMove
+	for rows.Next() {
+		move := &models.ChannelMove{}
+		err := rows.Scan(
+			&move.ID,
+			&move.ProjectID,
+			&move.ChannelName,
+			&move.Action,
+			&move.FromVersionID,
+			&move.ToVersionID,
+			&move.MovedBy,
+			&move.CreatedAt,
+			&move.FromVersion,
+			&move.FromHash,
+			&move.ToVersion,
+			&move.ToHash,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan channel move: %w", err)
+		}
+		moves = append(moves, move)
+	}
+	return moves, rows.Err()
+}
@@ -1,69 +1,230 @@
 package database
 
 import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
+	"encoding/hex"
 	"fmt"
 	"time"
 
+	"chchma.com/cloudlite-sync/internal/idgen"
 	"chchma.com/cloudlite-sync/internal/models"
 )
 
-// CreateCredential 创建凭证
-func (db *DB) CreateCredential(credential *models.Credential) error {
-	query := `INSERT INTO credentials (id, project_id, token, is_active, created_at, updated_at) 
-			  VALUES (?, ?, ?, ?, ?, ?)`
+// credentialColumns 查询凭证时的列，顺序与 scanCredential 一致
+const credentialColumns = `id, project_id, token, token_prefix, token_salt, is_active, scopes, name, description, expires_at,
+	allowed_cidrs, last_used_at, last_used_ip, created_at, updated_at`
 
-	now := time.Now()
-	_, err := db.Exec(query, credential.ID, credential.ProjectID, credential.Token, credential.IsActive, now, now)
+// tokenPrefixLength 保存为明文的令牌前缀长度
+const tokenPrefixLength = 8
+
+// hashToken 计算令牌的加盐哈希（以盐为密钥的 HMAC-SHA256）
+func hashToken(salt, token string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(token))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// tokenPrefix 返回令牌的明文前缀
+func tokenPrefix(token string) string {
+	if len(token) <= tokenPrefixLength {
+		return token
+	}
+	return token[:tokenPrefixLength]
+}
+
+// newTokenSalt 生成随机盐
+func newTokenSalt() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate salt: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// hashPlaintextTokens 将旧版本以明文保存的令牌（token_salt 为空）改为加盐哈希
+func hashPlaintextTokens(db *sql.DB) error {
+	rows, err := db.Query(`SELECT id, token FROM credentials WHERE token_salt = '' OR token_salt IS NULL`)
 	if err != nil {
-		return fmt.Errorf("failed to create credential: %w", err)
+		return fmt.Errorf("failed to query credentials: %w", err)
+	}
+	plaintext := make(map[string]string)
+	for rows.Next() {
+		var id, token string
+		if err := rows.Scan(&id, &token); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan credential: %w", err)
+		}
+		plaintext[id] = token
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(plaintext) == 0 {
+		return nil
 	}
 
-	credential.CreatedAt = now
-	credential.UpdatedAt = now
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	for id, token := range plaintext {
+		salt, err := newTokenSalt()
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE credentials SET token = ?, token_prefix = ?, token_salt = ? WHERE id = ?`,
+			hashToken(salt, token), tokenPrefix(token), salt, id)
+		if err != nil {
+			return fmt.Errorf("failed to hash credential token: %w", err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
 	return nil
 }
 
-// GetCredentialByToken 通过token获取凭证
-func (db *DB) GetCredentialByToken(token string) (*models.Credential, error) {
-	query := `SELECT id, project_id, token, is_active, created_at, updated_at 
-			  FROM credentials WHERE token = ? AND is_active = 1`
-
+// scanCredential 按 credentialColumns 的顺序读取一行凭证记录
+func scanCredential(row rowScanner) (*models.Credential, error) {
 	credential := &models.Credential{}
-	err := db.QueryRow(query, token).Scan(
+	var expiresAt, lastUsedAt sql.NullTime
+	err := row.Scan(
 		&credential.ID,
 		&credential.ProjectID,
-		&credential.Token,
+		&credential.TokenHash,
+		&credential.TokenPrefix,
+		&credential.TokenSalt,
 		&credential.IsActive,
+		&credential.Scopes,
+		&credential.Name,
+		&credential.Description,
+		&expiresAt,
+		&credential.AllowedCIDRs,
+		&lastUsedAt,
+		&credential.LastUsedIP,
 		&credential.CreatedAt,
 		&credential.UpdatedAt,
 	)
+	if err != nil {
+		return nil, err
+	}
+	if expiresAt.Valid {
+		credential.ExpiresAt = &expiresAt.Time
+	}
+	if lastUsedAt.Valid {
+		credential.LastUsedAt = &lastUsedAt.Time
+	}
+	return credential, nil
+}
 
+// CreateCredential 创建凭证，只保存 credential.Token 的前缀和加盐哈希，明文令牌保留在 credential 中供调用方展示一次
+func (db *DB) CreateCredential(credential *models.Credential) error {
+	salt, err := newTokenSalt()
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return nil, nil
+		return err
+	}
+	credential.TokenSalt = salt
+	credential.TokenHash = hashToken(salt, credential.Token)
+	credential.TokenPrefix = tokenPrefix(credential.Token)
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 哈希加了盐，相同的令牌不会触发唯一约束，需要逐个比较同前缀的凭证
+	exists, err := tokenExistsTx(tx, credential.Token)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return fmt.Errorf("failed to create credential: %w", idgen.ErrCollision)
+	}
+
+	query := `INSERT INTO credentials (id, project_id, token, token_prefix, token_salt, is_active, scopes, name, description,
+			  expires_at, allowed_cidrs, created_at, updated_at) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now()
+	_, err = tx.Exec(query, credential.ID, credential.ProjectID, credential.TokenHash, credential.TokenPrefix, credential.TokenSalt,
+		credential.IsActive, credential.Scopes, credential.Name, credential.Description, credential.ExpiresAt, credential.AllowedCIDRs, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create credential: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	credential.CreatedAt = now
+	credential.UpdatedAt = now
+	return nil
+}
+
+// tokenExistsTx 检查是否已有凭证（包括已停用的）使用该令牌
+func tokenExistsTx(tx *sql.Tx, token string) (bool, error) {
+	rows, err := tx.Query(`SELECT token, token_salt FROM credentials WHERE token_prefix = ?`, tokenPrefix(token))
+	if err != nil {
+		return false, fmt.Errorf("failed to query credentials: %w", err)
+	}
+	defer rows.Close()
+
+	exists := false
+	for rows.Next() {
+		var hash, salt string
+		if err := rows.Scan(&hash, &salt); err != nil {
+			return false, fmt.Errorf("failed to scan credential: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(hashToken(salt, token)), []byte(hash)) == 1 {
+			exists = true
+		}
+	}
+	return exists, rows.Err()
+}
+
+// GetCredentialByToken 通过token获取有效的凭证。按明文前缀找出候选凭证，再以常数时间比较加盐哈希
+func (db *DB) GetCredentialByToken(token string) (*models.Credential, error) {
+	// 回收站中项目的凭证不可用
+	query := `SELECT ` + credentialColumns + ` FROM credentials WHERE token_prefix = ? AND is_active = 1
+			  AND project_id NOT IN (SELECT id FROM projects WHERE deleted_at IS NOT NULL)`
+
+	rows, err := db.Query(query, tokenPrefix(token))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get credential: %w", err)
+	}
+	defer rows.Close()
+
+	var found *models.Credential
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan credential: %w", err)
+		}
+		if subtle.ConstantTimeCompare([]byte(hashToken(credential.TokenSalt, token)), []byte(credential.TokenHash)) == 1 {
+			found = credential
 		}
+	}
+	if err := rows.Err(); err != nil {
 		return nil, fmt.Errorf("failed to get credential: %w", err)
 	}
 
-	return credential, nil
+	return found, nil
 }
 
 // GetCredential 获取凭证
 func (db *DB) GetCredential(id string) (*models.Credential, error) {
-	query := `SELECT id, project_id, token, is_active, created_at, updated_at 
-			  FROM credentials WHERE id = ?`
-
-	credential := &models.Credential{}
-	err := db.QueryRow(query, id).Scan(
-		&credential.ID,
-		&credential.ProjectID,
-		&credential.Token,
-		&credential.IsActive,
-		&credential.CreatedAt,
-		&credential.UpdatedAt,
-	)
+	query := `SELECT ` + credentialColumns + ` FROM credentials WHERE id = ?`
 
+	credential, err := scanCredential(db.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -86,8 +247,7 @@ func (db *DB) ListCredentials(projectID string, page, pageSize int) ([]*models.C
 
 	// 获取分页数据
 	offset := (page - 1) * pageSize
-	query := `SELECT id, project_id, token, is_active, created_at, updated_at 
-			  FROM credentials WHERE project_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	query := `SELECT ` + credentialColumns + ` FROM credentials WHERE project_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`
 
 	rows, err := db.Query(query, projectID, pageSize, offset)
 	if err != nil {
@@ -97,15 +257,7 @@ func (db *DB) ListCredentials(projectID string, page, pageSize int) ([]*models.C
 
 	var credentials []*models.Credential
 	for rows.Next() {
-		credential := &models.Credential{}
-		err := rows.Scan(
-			&credential.ID,
-			&credential.ProjectID,
-			&credential.Token,
-			&credential.IsActive,
-			&credential.CreatedAt,
-			&credential.UpdatedAt,
-		)
+		credential, err := scanCredential(rows)
 		if err != nil {
 			return nil, 0, fmt.Errorf("failed to scan credential: %w", err)
 		}
@@ -115,12 +267,14 @@ func (db *DB) ListCredentials(projectID string, page, pageSize int) ([]*models.C
 	return credentials, total, nil
 }
 
-// UpdateCredential 更新凭证
+// UpdateCredential 更新凭证的状态、权限、名称、过期时间和地址限制
 func (db *DB) UpdateCredential(credential *models.Credential) error {
-	query := `UPDATE credentials SET is_active = ?, updated_at = ? WHERE id = ?`
+	query := `UPDATE credentials SET is_active = ?, scopes = ?, name = ?, description = ?, expires_at = ?, allowed_cidrs = ?, updated_at = ?
+			  WHERE id = ?`
 
 	now := time.Now()
-	_, err := db.Exec(query, credential.IsActive, now, credential.ID)
+	_, err := db.Exec(query, credential.IsActive, credential.Scopes, credential.Name, credential.Description,
+		credential.ExpiresAt, credential.AllowedCIDRs, now, credential.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update credential: %w", err)
 	}
@@ -129,6 +283,15 @@ func (db *DB) UpdateCredential(credential *models.Credential) error {
 	return nil
 }
 
+// TouchCredential 记录凭证最近一次成功调用 API 的时间和客户端地址
+func (db *DB) TouchCredential(id, ip string) error {
+	_, err := db.Exec(`UPDATE credentials SET last_used_at = ?, last_used_ip = ? WHERE id = ?`, time.Now(), ip, id)
+	if err != nil {
+		return fmt.Errorf("failed to update credential usage: %w", err)
+	}
+	return nil
+}
+
 // DeleteCredential 删除凭证
 func (db *DB) DeleteCredential(id string) error {
 	query := `DELETE FROM credentials WHERE id = ?`
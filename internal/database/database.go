@@ -3,8 +3,7 @@ package database
 import (
 	"database/sql"
 	"fmt"
-	"math/rand"
-	"time"
+	"log"
 
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -14,7 +13,8 @@ type DB struct {
 }
 
 func New(dbPath string) (*DB, error) {
-	db, err := sql.Open("sqlite3", dbPath)
+	// 启用外键约束，删除数据时必须先删除引用它的记录
+	db, err := sql.Open("sqlite3", dbPath+"?_foreign_keys=on")
 	if err != nil {
 		return nil, fmt.Errorf("failed to open database: %w", err)
 	}
@@ -27,9 +27,103 @@ func New(dbPath string) (*DB, error) {
 		return nil, fmt.Errorf("failed to init tables: %w", err)
 	}
 
+	if err := migrateTables(db); err != nil {
+		return nil, fmt.Errorf("failed to migrate tables: %w", err)
+	}
+
+	if err := cleanupForeignKeyViolations(db); err != nil {
+		return nil, fmt.Errorf("failed to clean up orphaned rows: %w", err)
+	}
+
+	if err := hashPlaintextTokens(db); err != nil {
+		return nil, fmt.Errorf("failed to hash credential tokens: %w", err)
+	}
+
 	return &DB{db}, nil
 }
 
+// maxForeignKeyPasses 清理孤立记录的最大轮数
+const maxForeignKeyPasses = 5
+
+// cleanupForeignKeyViolations 用 PRAGMA foreign_key_check 找出并删除引用的记录已不存在的孤立记录。
+// 旧版本未启用外键约束，删除项目时只删除了项目记录，留下的凭证、版本等记录在启用约束后会导致写入失败。
+// 删除孤立版本时一并删除其元数据并释放共享对象的引用，不再被引用的存储对象由存储一致性检查作为孤立对象清理
+func cleanupForeignKeyViolations(db *sql.DB) error {
+	for pass := 0; pass < maxForeignKeyPasses; pass++ {
+		rows, err := db.Query(`PRAGMA foreign_key_check`)
+		if err != nil {
+			return fmt.Errorf("failed to check foreign keys: %w", err)
+		}
+		violations := make(map[string][]int64)
+		for rows.Next() {
+			var table, parent string
+			var rowid sql.NullInt64
+			var fkid int
+			if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+				rows.Close()
+				return fmt.Errorf("failed to scan foreign key violation: %w", err)
+			}
+			if rowid.Valid {
+				violations[table] = append(violations[table], rowid.Int64)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return fmt.Errorf("failed to check foreign keys: %w", err)
+		}
+		if len(violations) == 0 {
+			return nil
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return fmt.Errorf("failed to begin transaction: %w", err)
+		}
+		for table, rowids := range violations {
+			for _, rowid := range rowids {
+				if err := deleteOrphanTx(tx, table, rowid); err != nil {
+					tx.Rollback()
+					return err
+				}
+			}
+			log.Printf("Removed %d orphaned rows from %s", len(rowids), table)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("failed to commit transaction: %w", err)
+		}
+	}
+	return fmt.Errorf("foreign key violations remain after %d passes", maxForeignKeyPasses)
+}
+
+// deleteOrphanTx 删除一条孤立记录，先删除引用它的子记录
+func deleteOrphanTx(tx *sql.Tx, table string, rowid int64) error {
+	switch table {
+	case "database_versions":
+		var ossKey string
+		err := tx.QueryRow(`SELECT oss_key FROM database_versions WHERE rowid = ?`, rowid).Scan(&ossKey)
+		if err != nil {
+			return fmt.Errorf("failed to get orphaned version: %w", err)
+		}
+		if _, err := releaseBlobTx(tx, ossKey); err != nil {
+			return err
+		}
+		_, err = tx.Exec(`DELETE FROM version_metadata WHERE version_id = (SELECT id FROM database_versions WHERE rowid = ?)`, rowid)
+		if err != nil {
+			return fmt.Errorf("failed to delete orphaned version metadata: %w", err)
+		}
+	case "upload_sessions":
+		_, err := tx.Exec(`DELETE FROM upload_parts WHERE session_id = (SELECT id FROM upload_sessions WHERE rowid = ?)`, rowid)
+		if err != nil {
+			return fmt.Errorf("failed to delete orphaned upload parts: %w", err)
+		}
+	}
+	// 表名来自 foreign_key_check 的结果
+	if _, err := tx.Exec(`DELETE FROM "`+table+`" WHERE rowid = ?`, rowid); err != nil {
+		return fmt.Errorf("failed to delete orphaned row from %s: %w", table, err)
+	}
+	return nil
+}
+
 func initTables(db *sql.DB) error {
 	queries := []string{
 		`CREATE TABLE IF NOT EXISTS projects (
@@ -37,14 +131,28 @@ func initTables(db *sql.DB) error {
 			name TEXT NOT NULL,
 			description TEXT,
 			website TEXT DEFAULT '',
+			download_mode TEXT DEFAULT 'proxy',
+			required_tables TEXT DEFAULT '',
+			retain_count INTEGER DEFAULT 0,
+			retain_days INTEGER DEFAULT 0,
+			deleted_at DATETIME,
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
 		)`,
 		`CREATE TABLE IF NOT EXISTS credentials (
 			id TEXT PRIMARY KEY,
 			project_id TEXT NOT NULL,
-			token TEXT UNIQUE NOT NULL,
+			token TEXT UNIQUE NOT NULL, -- 令牌的加盐哈希
+			token_prefix TEXT DEFAULT '',
+			token_salt TEXT DEFAULT '',
 			is_active BOOLEAN DEFAULT 1,
+			scopes TEXT DEFAULT 'read,write,promote,list',
+			name TEXT DEFAULT '',
+			description TEXT DEFAULT '',
+			expires_at DATETIME,
+			allowed_cidrs TEXT DEFAULT '',
+			last_used_at DATETIME,
+			last_used_ip TEXT DEFAULT '',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (project_id) REFERENCES projects(id)
@@ -54,14 +162,135 @@ func initTables(db *sql.DB) error {
 			project_id TEXT NOT NULL,
 			version TEXT NOT NULL,
 			file_hash TEXT NOT NULL,
+			sha256 TEXT DEFAULT '',
 			file_name TEXT NOT NULL,
 			file_size INTEGER NOT NULL,
+			stored_size INTEGER DEFAULT 0,
+			compression TEXT DEFAULT '',
+			key_id TEXT DEFAULT '',
 			oss_key TEXT NOT NULL,
 			description TEXT,
 			is_latest BOOLEAN DEFAULT 0,
+			pinned BOOLEAN DEFAULT 0,
+			label TEXT DEFAULT '',
+			schema_version INTEGER,
+			broken TEXT DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS version_metadata (
+			version_id TEXT NOT NULL,
+			key TEXT NOT NULL,
+			value TEXT NOT NULL,
+			PRIMARY KEY (version_id, key),
+			FOREIGN KEY (version_id) REFERENCES database_versions(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS channels (
+			id TEXT PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			name TEXT NOT NULL,
+			version_id TEXT NOT NULL,
+			updated_by TEXT DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (project_id, name),
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS channel_moves (
+			id TEXT PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			channel_name TEXT NOT NULL,
+			action TEXT NOT NULL,
+			from_version_id TEXT DEFAULT '',
+			to_version_id TEXT NOT NULL,
+			moved_by TEXT DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS pruned_versions (
+			id TEXT PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			version_id TEXT NOT NULL,
+			version TEXT NOT NULL,
+			file_hash TEXT NOT NULL,
+			file_name TEXT NOT NULL,
+			file_size INTEGER NOT NULL,
+			reason TEXT NOT NULL,
+			trigger TEXT NOT NULL,
+			created_at DATETIME NOT NULL,
+			pruned_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS version_deltas (
+			id TEXT PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			from_version_id TEXT NOT NULL,
+			to_version_id TEXT NOT NULL,
+			page_size INTEGER NOT NULL,
+			changed_pages INTEGER NOT NULL,
+			delta_size INTEGER NOT NULL,
+			key_id TEXT DEFAULT '',
+			oss_key TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			UNIQUE (from_version_id, to_version_id),
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS blobs (
+			sha256 TEXT PRIMARY KEY,
+			oss_key TEXT NOT NULL,
+			file_size INTEGER NOT NULL,
+			stored_size INTEGER NOT NULL,
+			compression TEXT DEFAULT '',
+			ref_count INTEGER NOT NULL DEFAULT 0,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		)`,
+		`CREATE TABLE IF NOT EXISTS project_deletions (
+			project_id TEXT PRIMARY KEY,
+			project_name TEXT NOT NULL,
+			deleted_by TEXT DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'trashed',
+			purge_after DATETIME NOT NULL,
+			total_versions INTEGER DEFAULT 0,
+			purged_versions INTEGER DEFAULT 0,
+			error TEXT DEFAULT '',
+			deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			purged_at DATETIME
+		)`,
+		`CREATE TABLE IF NOT EXISTS project_keys (
+			id TEXT PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			master_key_id TEXT NOT NULL,
+			wrapped_key BLOB NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			FOREIGN KEY (project_id) REFERENCES projects(id)
+		)`,
+		`CREATE TABLE IF NOT EXISTS upload_sessions (
+			id TEXT PRIMARY KEY,
+			project_id TEXT NOT NULL,
+			credential_id TEXT NOT NULL,
+			file_name TEXT NOT NULL,
+			description TEXT,
+			label TEXT DEFAULT '',
+			metadata TEXT DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			version_id TEXT DEFAULT '',
 			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			updated_at DATETIME DEFAULT CURRENT_TIMESTAMP,
 			FOREIGN KEY (project_id) REFERENCES projects(id)
 		)`,
+		`CREATE TABLE IF NOT EXISTS upload_parts (
+			session_id TEXT NOT NULL,
+			part_number INTEGER NOT NULL,
+			size INTEGER NOT NULL,
+			part_hash TEXT NOT NULL,
+			oss_key TEXT NOT NULL,
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (session_id, part_number),
+			FOREIGN KEY (session_id) REFERENCES upload_sessions(id)
+		)`,
 		`CREATE TABLE IF NOT EXISTS jwt_projects (
 			id TEXT PRIMARY KEY,
 			name TEXT NOT NULL,
@@ -95,26 +324,103 @@ func initTables(db *sql.DB) error {
 	return nil
 }
 
-// GenerateProjectID 生成唯一的项目ID
-func GenerateProjectID() string {
-	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+// migrateTables 为旧版本创建的数据库补充新增的列
+func migrateTables(db *sql.DB) error {
+	columns := []struct {
+		table      string
+		column     string
+		definition string
+		backfill   string // 新增列后为已有数据补全取值的语句，可为空
+	}{
+		{"projects", "download_mode", "TEXT DEFAULT 'proxy'", ""},
+		{"projects", "required_tables", "TEXT DEFAULT ''", ""},
+		{"projects", "retain_count", "INTEGER DEFAULT 0", ""},
+		{"projects", "retain_days", "INTEGER DEFAULT 0", ""},
+		{"projects", "deleted_at", "DATETIME", ""},
+		{"database_versions", "stored_size", "INTEGER DEFAULT 0", "UPDATE database_versions SET stored_size = file_size"},
+		{"database_versions", "compression", "TEXT DEFAULT ''", ""},
+		{"database_versions", "key_id", "TEXT DEFAULT ''", ""},
+		{"database_versions", "pinned", "BOOLEAN DEFAULT 0", ""},
+		// 已有凭证保持原来的全部权限
+		{"credentials", "scopes", "TEXT DEFAULT 'read,write,promote,list'", ""},
+		{"credentials", "name", "TEXT DEFAULT ''", ""},
+		{"credentials", "description", "TEXT DEFAULT ''", ""},
+		{"credentials", "expires_at", "DATETIME", ""},
+		{"credentials", "allowed_cidrs", "TEXT DEFAULT ''", ""},
+		{"credentials", "last_used_at", "DATETIME", ""},
+		{"credentials", "last_used_ip", "TEXT DEFAULT ''", ""},
+		{"credentials", "token_prefix", "TEXT DEFAULT ''", ""},
+		{"credentials", "token_salt", "TEXT DEFAULT ''", ""},
+		{"database_versions", "label", "TEXT DEFAULT ''", ""},
+		// 已有版本的 schema 版本未知，保持为 NULL，由后台任务读取存储中的文件补全
+		{"database_versions", "schema_version", "INTEGER", ""},
+		{"database_versions", "sha256", "TEXT DEFAULT ''", ""},
+		{"database_versions", "broken", "TEXT DEFAULT ''", ""},
+		{"upload_sessions", "label", "TEXT DEFAULT ''", ""},
+		{"upload_sessions", "metadata", "TEXT DEFAULT ''", ""},
+		{"version_deltas", "key_id", "TEXT DEFAULT ''", ""},
+	}
+
+	for _, c := range columns {
+		exists, err := columnExists(db, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if exists {
+			continue
+		}
+		query := fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", c.table, c.column, c.definition)
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to add column %s.%s: %w", c.table, c.column, err)
+		}
+		if c.backfill != "" {
+			if _, err := db.Exec(c.backfill); err != nil {
+				return fmt.Errorf("failed to backfill column %s.%s: %w", c.table, c.column, err)
+			}
+		}
+	}
 
-	id := make([]byte, 8)
-	for i := range id {
-		id[i] = letters[r.Intn(len(letters))]
+	// 依赖新增列的索引在补充列之后创建
+	indexes := []string{
+		// 标签在项目内唯一，未设置标签的版本不受限制
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_database_versions_label
+			ON database_versions(project_id, label) WHERE label != ''`,
+		`CREATE INDEX IF NOT EXISTS idx_database_versions_sha256 ON database_versions(project_id, sha256)`,
+		`CREATE INDEX IF NOT EXISTS idx_credentials_token_prefix ON credentials(token_prefix)`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS idx_blobs_oss_key ON blobs(oss_key)`,
+	}
+	for _, query := range indexes {
+		if _, err := db.Exec(query); err != nil {
+			return fmt.Errorf("failed to create index: %w", err)
+		}
 	}
-	return string(id)
+
+	return nil
 }
 
-// GenerateToken 生成唯一的凭证token
-func GenerateToken() string {
-	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+// columnExists 检查表中是否存在指定列
+func columnExists(db *sql.DB, table, column string) (bool, error) {
+	rows, err := db.Query(fmt.Sprintf("PRAGMA table_info(%s)", table))
+	if err != nil {
+		return false, fmt.Errorf("failed to get table info: %w", err)
+	}
+	defer rows.Close()
 
-	token := make([]byte, 32)
-	for i := range token {
-		token[i] = letters[r.Intn(len(letters))]
+	for rows.Next() {
+		var (
+			cid        int
+			name       string
+			columnType string
+			notNull    bool
+			defaultVal sql.NullString
+			primaryKey int
+		)
+		if err := rows.Scan(&cid, &name, &columnType, &notNull, &defaultVal, &primaryKey); err != nil {
+			return false, fmt.Errorf("failed to scan table info: %w", err)
+		}
+		if name == column {
+			return true, nil
+		}
 	}
-	return string(token)
+	return false, rows.Err()
 }
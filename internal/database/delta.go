@@ -0,0 +1,98 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+)
+
+// CreateVersionDelta 记录已缓存的版本差量，同一对版本重复生成时覆盖
+func (db *DB) CreateVersionDelta(delta *models.VersionDelta) error {
+	query := `INSERT OR REPLACE INTO version_deltas (id, project_id, from_version_id, to_version_id, page_size, changed_pages, delta_size, key_id, oss_key, created_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now()
+	_, err := db.Exec(query, delta.ID, delta.ProjectID, delta.FromVersionID, delta.ToVersionID,
+		delta.PageSize, delta.ChangedPages, delta.DeltaSize, delta.KeyID, delta.OSSKey, now)
+	if err != nil {
+		return fmt.Errorf("failed to create version delta: %w", err)
+	}
+
+	delta.CreatedAt = now
+	return nil
+}
+
+// GetVersionDelta 获取两个版本之间的差量
+func (db *DB) GetVersionDelta(fromVersionID, toVersionID string) (*models.VersionDelta, error) {
+	query := `SELECT id, project_id, from_version_id, to_version_id, page_size, changed_pages, delta_size, key_id, oss_key, created_at
+			  FROM version_deltas WHERE from_version_id = ? AND to_version_id = ?`
+
+	delta := &models.VersionDelta{}
+	err := db.QueryRow(query, fromVersionID, toVersionID).Scan(
+		&delta.ID,
+		&delta.ProjectID,
+		&delta.FromVersionID,
+		&delta.ToVersionID,
+		&delta.PageSize,
+		&delta.ChangedPages,
+		&delta.DeltaSize,
+		&delta.KeyID,
+		&delta.OSSKey,
+		&delta.CreatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get version delta: %w", err)
+	}
+
+	return delta, nil
+}
+
+// ListVersionDeltas 获取与指定版本相关（作为源或目标）的全部差量
+func (db *DB) ListVersionDeltas(versionID string) ([]*models.VersionDelta, error) {
+	query := `SELECT id, project_id, from_version_id, to_version_id, page_size, changed_pages, delta_size, key_id, oss_key, created_at
+			  FROM version_deltas WHERE from_version_id = ? OR to_version_id = ?`
+
+	rows, err := db.Query(query, versionID, versionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query version deltas: %w", err)
+	}
+	defer rows.Close()
+
+	var deltas []*models.VersionDelta
+	for rows.Next() {
+		delta := &models.VersionDelta{}
+		err := rows.Scan(
+			&delta.ID,
+			&delta.ProjectID,
+			&delta.FromVersionID,
+			&delta.ToVersionID,
+			&delta.PageSize,
+			&delta.ChangedPages,
+			&delta.DeltaSize,
+			&delta.KeyID,
+			&delta.OSSKey,
+			&delta.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan version delta: %w", err)
+		}
+		deltas = append(deltas, delta)
+	}
+
+	return deltas, nil
+}
+
+// DeleteVersionDelta 删除差量记录
+func (db *DB) DeleteVersionDelta(id string) error {
+	_, err := db.Exec(`DELETE FROM version_deltas WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete version delta: %w", err)
+	}
+	return nil
+}
@@ -1,9 +1,10 @@
 package database
 
 import (
-	"math/rand"
+	"fmt"
 	"time"
 
+	"chchma.com/cloudlite-sync/internal/idgen"
 	"chchma.com/cloudlite-sync/internal/models"
 )
 
@@ -16,6 +17,9 @@ func (db *DB) CreateJWTProject(project *models.JWTProject) error {
 	now := time.Now()
 	_, err := db.Exec(query, project.ID, project.Name, project.Description,
 		project.PublicKey, project.PrivateKey, now, now)
+	if isPrimaryKeyViolation(err) {
+		return fmt.Errorf("jwt project %s: %w", project.ID, idgen.ErrCollision)
+	}
 	return err
 }
 
@@ -90,6 +94,9 @@ func (db *DB) CreateJWTToken(token *models.JWTToken) error {
 	now := time.Now()
 	_, err := db.Exec(query, token.ID, token.ProjectID, token.Purpose, token.Username,
 		token.Role, token.Token, token.IsActive, token.ExpiresAt, now, now)
+	if isPrimaryKeyViolation(err) {
+		return fmt.Errorf("jwt token %s: %w", token.ID, idgen.ErrCollision)
+	}
 	return err
 }
 
@@ -173,27 +180,3 @@ func (db *DB) GetJWTTokenByToken(tokenStr string) (*models.JWTToken, error) {
 	}
 	return token, nil
 }
-
-// 生成JWT Token ID
-func GenerateJWTTokenID() string {
-	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	id := make([]byte, 12)
-	for i := range id {
-		id[i] = letters[r.Intn(len(letters))]
-	}
-	return string(id)
-}
-
-// 生成JWT Token字符串
-func GenerateJWTTokenString() string {
-	const letters = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789"
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-
-	token := make([]byte, 64)
-	for i := range token {
-		token[i] = letters[r.Intn(len(letters))]
-	}
-	return string(token)
-}
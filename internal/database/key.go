@@ -0,0 +1,101 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+)
+
+const projectKeyColumns = `id, project_id, master_key_id, wrapped_key, created_at, updated_at`
+
+func scanProjectKey(row rowScanner) (*models.ProjectKey, error) {
+	key := &models.ProjectKey{}
+	err := row.Scan(
+		&key.ID,
+		&key.ProjectID,
+		&key.MasterKeyID,
+		&key.WrappedKey,
+		&key.CreatedAt,
+		&key.UpdatedAt,
+	)
+	return key, err
+}
+
+// CreateProjectKey 保存包装后的项目数据密钥
+func (db *DB) CreateProjectKey(key *models.ProjectKey) error {
+	query := `INSERT INTO project_keys (id, project_id, master_key_id, wrapped_key, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?)`
+
+	now := time.Now()
+	_, err := db.Exec(query, key.ID, key.ProjectID, key.MasterKeyID, key.WrappedKey, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create project key: %w", err)
+	}
+
+	key.CreatedAt = now
+	key.UpdatedAt = now
+	return nil
+}
+
+// GetProjectKey 获取数据密钥
+func (db *DB) GetProjectKey(id string) (*models.ProjectKey, error) {
+	query := `SELECT ` + projectKeyColumns + ` FROM project_keys WHERE id = ?`
+
+	key, err := scanProjectKey(db.QueryRow(query, id))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get project key: %w", err)
+	}
+	return key, nil
+}
+
+// GetActiveProjectKey 获取项目当前用于加密新版本的数据密钥（最近创建的一个）
+func (db *DB) GetActiveProjectKey(projectID string) (*models.ProjectKey, error) {
+	query := `SELECT ` + projectKeyColumns + ` FROM project_keys
+			  WHERE project_id = ? ORDER BY created_at DESC LIMIT 1`
+
+	key, err := scanProjectKey(db.QueryRow(query, projectID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get project key: %w", err)
+	}
+	return key, nil
+}
+
+// ListProjectKeys 获取全部数据密钥
+func (db *DB) ListProjectKeys() ([]*models.ProjectKey, error) {
+	query := `SELECT ` + projectKeyColumns + ` FROM project_keys ORDER BY created_at`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query project keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []*models.ProjectKey
+	for rows.Next() {
+		key, err := scanProjectKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan project key: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// UpdateProjectKeyWrap 用新的主密钥重新包装数据密钥后更新
+func (db *DB) UpdateProjectKeyWrap(id, masterKeyID string, wrappedKey []byte) error {
+	query := `UPDATE project_keys SET master_key_id = ?, wrapped_key = ?, updated_at = ? WHERE id = ?`
+
+	_, err := db.Exec(query, masterKeyID, wrappedKey, time.Now(), id)
+	if err != nil {
+		return fmt.Errorf("failed to update project key: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"chchma.com/cloudlite-sync/internal/models"
+	"github.com/mattn/go-sqlite3"
+)
+
+// ErrLabelExists 项目中已有使用相同标签的版本
+var ErrLabelExists = errors.New("version label already exists")
+
+// isUniqueViolation 判断错误是否由唯一约束冲突引起
+func isUniqueViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
+// isPrimaryKeyViolation 判断错误是否由主键冲突引起
+func isPrimaryKeyViolation(err error) bool {
+	var sqliteErr sqlite3.Error
+	return errors.As(err, &sqliteErr) && sqliteErr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey
+}
+
+// insertMetadataTx 写入版本的键值元数据
+func insertMetadataTx(tx *sql.Tx, versionID string, metadata map[string]string) error {
+	for key, value := range metadata {
+		_, err := tx.Exec(`INSERT INTO version_metadata (version_id, key, value) VALUES (?, ?, ?)`, versionID, key, value)
+		if err != nil {
+			return fmt.Errorf("failed to create version metadata: %w", err)
+		}
+	}
+	return nil
+}
+
+// LoadVersionMetadata 为一组版本填充 Metadata，没有元数据的版本保持为 nil
+func (db *DB) LoadVersionMetadata(versions ...*models.DatabaseVersion) error {
+	if len(versions) == 0 {
+		return nil
+	}
+	byID := make(map[string]*models.DatabaseVersion, len(versions))
+	args := make([]interface{}, 0, len(versions))
+	for _, version := range versions {
+		if version == nil {
+			continue
+		}
+		byID[version.ID] = version
+		args = append(args, version.ID)
+	}
+	if len(args) == 0 {
+		return nil
+	}
+
+	query := `SELECT version_id, key, value FROM version_metadata
+			  WHERE version_id IN (?` + strings.Repeat(", ?", len(args)-1) + `)`
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to query version metadata: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var versionID, key, value string
+		if err := rows.Scan(&versionID, &key, &value); err != nil {
+			return fmt.Errorf("failed to scan version metadata: %w", err)
+		}
+		version := byID[versionID]
+		if version.Metadata == nil {
+			version.Metadata = make(map[string]string)
+		}
+		version.Metadata[key] = value
+	}
+	return rows.Err()
+}
+
+// ListVersionsByMetadata 获取元数据同时满足全部键值条件的版本（分页），按创建时间从新到旧排列
+func (db *DB) ListVersionsByMetadata(projectID string, filter map[string]string, page, pageSize int) ([]*models.DatabaseVersion, int, error) {
+	// 按键排序，保证生成的查询稳定
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	where := `project_id = ?`
+	args := []interface{}{projectID}
+	for _, key := range keys {
+		where += ` AND EXISTS(SELECT 1 FROM version_metadata m WHERE m.version_id = database_versions.id AND m.key = ? AND m.value = ?)`
+		args = append(args, key, filter[key])
+	}
+
+	var total int
+	err := db.QueryRow(`SELECT COUNT(*) FROM database_versions WHERE `+where, args...).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to count database versions: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	query := `SELECT ` + versionColumns + ` FROM database_versions WHERE ` + where + ` ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	rows, err := db.Query(query, append(args, pageSize, offset)...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to query database versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*models.DatabaseVersion
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to scan database version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions, total, rows.Err()
+}
@@ -5,16 +5,44 @@ import (
 	"fmt"
 	"time"
 
+	"chchma.com/cloudlite-sync/internal/idgen"
 	"chchma.com/cloudlite-sync/internal/models"
 )
 
+const projectColumns = `id, name, description, website, download_mode, required_tables, retain_count, retain_days,
+	created_at, updated_at`
+
+func scanProject(row rowScanner) (*models.Project, error) {
+	project := &models.Project{}
+	err := row.Scan(
+		&project.ID,
+		&project.Name,
+		&project.Description,
+		&project.Website,
+		&project.DownloadMode,
+		&project.RequiredTables,
+		&project.RetainCount,
+		&project.RetainDays,
+		&project.CreatedAt,
+		&project.UpdatedAt,
+	)
+	return project, err
+}
+
 // CreateProject 创建项目
 func (db *DB) CreateProject(project *models.Project) error {
-	query := `INSERT INTO projects (id, name, description, website, created_at, updated_at) 
-			  VALUES (?, ?, ?, ?, ?, ?)`
+	if project.DownloadMode == "" {
+		project.DownloadMode = models.DownloadModeProxy
+	}
+
+	query := `INSERT INTO projects (` + projectColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	now := time.Now()
-	_, err := db.Exec(query, project.ID, project.Name, project.Description, project.Website, now, now)
+	_, err := db.Exec(query, project.ID, project.Name, project.Description, project.Website, project.DownloadMode,
+		project.RequiredTables, project.RetainCount, project.RetainDays, now, now)
+	if isPrimaryKeyViolation(err) {
+		return fmt.Errorf("failed to create project: project %s: %w", project.ID, idgen.ErrCollision)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create project: %w", err)
 	}
@@ -24,20 +52,11 @@ func (db *DB) CreateProject(project *models.Project) error {
 	return nil
 }
 
-// GetProject 获取项目
+// GetProject 获取项目，回收站中的项目视为不存在
 func (db *DB) GetProject(id string) (*models.Project, error) {
-	query := `SELECT id, name, description, website, created_at, updated_at FROM projects WHERE id = ?`
-
-	project := &models.Project{}
-	err := db.QueryRow(query, id).Scan(
-		&project.ID,
-		&project.Name,
-		&project.Description,
-		&project.Website,
-		&project.CreatedAt,
-		&project.UpdatedAt,
-	)
+	query := `SELECT ` + projectColumns + ` FROM projects WHERE id = ? AND deleted_at IS NULL`
 
+	project, err := scanProject(db.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -52,7 +71,7 @@ func (db *DB) GetProject(id string) (*models.Project, error) {
 func (db *DB) ListProjects(page, pageSize int) ([]*models.Project, int, error) {
 	// 获取总数
 	var total int
-	countQuery := `SELECT COUNT(*) FROM projects`
+	countQuery := `SELECT COUNT(*) FROM projects WHERE deleted_at IS NULL`
 	err := db.QueryRow(countQuery).Scan(&total)
 	if err != nil {
 		return nil, 0, fmt.Errorf("failed to count projects: %w", err)
@@ -60,8 +79,7 @@ func (db *DB) ListProjects(page, pageSize int) ([]*models.Project, int, error) {
 
 	// 获取分页数据
 	offset := (page - 1) * pageSize
-	query := `SELECT id, name, description, website, created_at, updated_at 
-			  FROM projects ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	query := `SELECT ` + projectColumns + ` FROM projects WHERE deleted_at IS NULL ORDER BY created_at DESC LIMIT ? OFFSET ?`
 
 	rows, err := db.Query(query, pageSize, offset)
 	if err != nil {
@@ -71,15 +89,7 @@ func (db *DB) ListProjects(page, pageSize int) ([]*models.Project, int, error) {
 
 	var projects []*models.Project
 	for rows.Next() {
-		project := &models.Project{}
-		err := rows.Scan(
-			&project.ID,
-			&project.Name,
-			&project.Description,
-			&project.Website,
-			&project.CreatedAt,
-			&project.UpdatedAt,
-		)
+		project, err := scanProject(rows)
 		if err != nil {
 			return nil, 0, fmt.Errorf("failed to scan project: %w", err)
 		}
@@ -89,12 +99,36 @@ func (db *DB) ListProjects(page, pageSize int) ([]*models.Project, int, error) {
 	return projects, total, nil
 }
 
+// ListRetentionProjects 获取设置了保留策略的项目
+func (db *DB) ListRetentionProjects() ([]*models.Project, error) {
+	query := `SELECT ` + projectColumns + ` FROM projects WHERE deleted_at IS NULL AND (retain_count > 0 OR retain_days > 0)`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query projects: %w", err)
+	}
+	defer rows.Close()
+
+	var projects []*models.Project
+	for rows.Next() {
+		project, err := scanProject(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan project: %w", err)
+		}
+		projects = append(projects, project)
+	}
+
+	return projects, rows.Err()
+}
+
 // UpdateProject 更新项目
 func (db *DB) UpdateProject(project *models.Project) error {
-	query := `UPDATE projects SET name = ?, description = ?, website = ?, updated_at = ? WHERE id = ?`
+	query := `UPDATE projects SET name = ?, description = ?, website = ?, download_mode = ?, required_tables = ?,
+			  retain_count = ?, retain_days = ?, updated_at = ? WHERE id = ?`
 
 	now := time.Now()
-	_, err := db.Exec(query, project.Name, project.Description, project.Website, now, project.ID)
+	_, err := db.Exec(query, project.Name, project.Description, project.Website, project.DownloadMode, project.RequiredTables,
+		project.RetainCount, project.RetainDays, now, project.ID)
 	if err != nil {
 		return fmt.Errorf("failed to update project: %w", err)
 	}
@@ -103,21 +137,70 @@ func (db *DB) UpdateProject(project *models.Project) error {
 	return nil
 }
 
-// DeleteProject 删除项目
-func (db *DB) DeleteProject(id string) error {
+// DeleteProject 删除项目及其全部数据记录并将删除记录标记为已清理，返回不再被任何版本引用、可以从存储中删除的对象键
+func (db *DB) DeleteProject(id string) ([]string, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 释放项目各版本对存储对象的引用，收集不再被引用的存储键
+	rows, err := tx.Query(`SELECT oss_key FROM database_versions WHERE project_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query project versions: %w", err)
+	}
+	var ossKeys []string
+	for rows.Next() {
+		var ossKey string
+		if err := rows.Scan(&ossKey); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		ossKeys = append(ossKeys, ossKey)
+	}
+	rows.Close()
+	var unreferenced []string
+	for _, ossKey := range ossKeys {
+		ok, err := releaseBlobTx(tx, ossKey)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			unreferenced = append(unreferenced, ossKey)
+		}
+	}
+
 	// 首先删除相关的凭证和数据库版本
 	queries := []string{
+		`DELETE FROM version_deltas WHERE project_id = ?`,
+		`DELETE FROM version_metadata WHERE version_id IN (SELECT id FROM database_versions WHERE project_id = ?)`,
 		`DELETE FROM database_versions WHERE project_id = ?`,
+		`DELETE FROM project_keys WHERE project_id = ?`,
+		`DELETE FROM pruned_versions WHERE project_id = ?`,
+		`DELETE FROM channel_moves WHERE project_id = ?`,
+		`DELETE FROM channels WHERE project_id = ?`,
 		`DELETE FROM credentials WHERE project_id = ?`,
+		`DELETE FROM upload_parts WHERE session_id IN (SELECT id FROM upload_sessions WHERE project_id = ?)`,
+		`DELETE FROM upload_sessions WHERE project_id = ?`,
 		`DELETE FROM projects WHERE id = ?`,
 	}
 
 	for _, query := range queries {
-		_, err := db.Exec(query, id)
+		_, err := tx.Exec(query, id)
 		if err != nil {
-			return fmt.Errorf("failed to delete project data: %w", err)
+			return nil, fmt.Errorf("failed to delete project data: %w", err)
 		}
 	}
+	now := time.Now()
+	_, err = tx.Exec(`UPDATE project_deletions SET status = ?, purged_versions = total_versions, error = '', updated_at = ?, purged_at = ?
+			  WHERE project_id = ?`, models.DeletionPurged, now, now, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to update project deletion: %w", err)
+	}
 
-	return nil
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return unreferenced, nil
 }
@@ -0,0 +1,154 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+)
+
+// ListStoredVersions 获取全部项目的版本，用于存储一致性检查
+func (db *DB) ListStoredVersions() ([]*models.DatabaseVersion, error) {
+	rows, err := db.Query(`SELECT ` + versionColumns + ` FROM database_versions ORDER BY project_id, created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*models.DatabaseVersion
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// ListReferencedObjectKeys 获取版本、差量缓存和分片上传引用的全部存储键
+func (db *DB) ListReferencedObjectKeys() (map[string]bool, error) {
+	rows, err := db.Query(`SELECT oss_key FROM database_versions
+			  UNION SELECT oss_key FROM version_deltas
+			  UNION SELECT oss_key FROM upload_parts`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query referenced keys: %w", err)
+	}
+	defer rows.Close()
+
+	keys := make(map[string]bool)
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, fmt.Errorf("failed to scan referenced key: %w", err)
+		}
+		keys[key] = true
+	}
+	return keys, rows.Err()
+}
+
+// ListCachedDeltas 获取全部项目的差量缓存记录
+func (db *DB) ListCachedDeltas() ([]*models.VersionDelta, error) {
+	rows, err := db.Query(`SELECT id, project_id, from_version_id, to_version_id, page_size, changed_pages, delta_size, key_id, oss_key, created_at
+			  FROM version_deltas`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query version deltas: %w", err)
+	}
+	defer rows.Close()
+
+	var deltas []*models.VersionDelta
+	for rows.Next() {
+		delta := &models.VersionDelta{}
+		err := rows.Scan(
+			&delta.ID,
+			&delta.ProjectID,
+			&delta.FromVersionID,
+			&delta.ToVersionID,
+			&delta.PageSize,
+			&delta.ChangedPages,
+			&delta.DeltaSize,
+			&delta.KeyID,
+			&delta.OSSKey,
+			&delta.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan version delta: %w", err)
+		}
+		deltas = append(deltas, delta)
+	}
+	return deltas, rows.Err()
+}
+
+// ListBlobReferences 获取全部共享对象，以及实际引用各对象的版本数（按存储键）
+func (db *DB) ListBlobReferences() ([]*models.Blob, map[string]int, error) {
+	rows, err := db.Query(`SELECT ` + blobColumns + ` FROM blobs`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to query blobs: %w", err)
+	}
+	var blobs []*models.Blob
+	for rows.Next() {
+		blob, err := scanBlob(rows)
+		if err != nil {
+			rows.Close()
+			return nil, nil, fmt.Errorf("failed to scan blob: %w", err)
+		}
+		blobs = append(blobs, blob)
+	}
+	rows.Close()
+
+	rows, err = db.Query(`SELECT oss_key, COUNT(*) FROM database_versions
+			  WHERE oss_key IN (SELECT oss_key FROM blobs) GROUP BY oss_key`)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to count blob references: %w", err)
+	}
+	defer rows.Close()
+	counts := make(map[string]int)
+	for rows.Next() {
+		var key string
+		var count int
+		if err := rows.Scan(&key, &count); err != nil {
+			return nil, nil, fmt.Errorf("failed to scan blob references: %w", err)
+		}
+		counts[key] = count
+	}
+	return blobs, counts, rows.Err()
+}
+
+// RecountBlobReferences 按实际引用的版本数重新计算共享对象的引用计数，没有版本引用时删除记录
+func (db *DB) RecountBlobReferences(sha256Hash string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	var refCount int
+	err = tx.QueryRow(`UPDATE blobs SET updated_at = ?,
+			  ref_count = (SELECT COUNT(*) FROM database_versions WHERE oss_key = blobs.oss_key)
+			  WHERE sha256 = ? RETURNING ref_count`, time.Now(), sha256Hash).Scan(&refCount)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to recount blob references: %w", err)
+	}
+	if refCount == 0 {
+		if _, err := tx.Exec(`DELETE FROM blobs WHERE sha256 = ?`, sha256Hash); err != nil {
+			return fmt.Errorf("failed to delete blob: %w", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// SetVersionBroken 标记版本的存储对象存在的问题，reason 为空时清除标记
+func (db *DB) SetVersionBroken(id, reason string) error {
+	_, err := db.Exec(`UPDATE database_versions SET broken = ? WHERE id = ?`, reason, id)
+	if err != nil {
+		return fmt.Errorf("failed to flag version: %w", err)
+	}
+	return nil
+}
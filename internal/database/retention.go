@@ -0,0 +1,60 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+)
+
+// CreatePrunedVersion 记录被保留策略清理的版本
+func (db *DB) CreatePrunedVersion(pruned *models.PrunedVersion) error {
+	query := `INSERT INTO pruned_versions (id, project_id, version_id, version, file_hash, file_name, file_size, reason, trigger, created_at, pruned_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	now := time.Now()
+	_, err := db.Exec(query, pruned.ID, pruned.ProjectID, pruned.VersionID, pruned.Version, pruned.FileHash,
+		pruned.FileName, pruned.FileSize, pruned.Reason, pruned.Trigger, pruned.CreatedAt, now)
+	if err != nil {
+		return fmt.Errorf("failed to create pruned version: %w", err)
+	}
+
+	pruned.PrunedAt = now
+	return nil
+}
+
+// ListPrunedVersions 获取项目最近的清理记录
+func (db *DB) ListPrunedVersions(projectID string, limit int) ([]*models.PrunedVersion, error) {
+	query := `SELECT id, project_id, version_id, version, file_hash, file_name, file_size, reason, trigger, created_at, pruned_at
+			  FROM pruned_versions WHERE project_id = ? ORDER BY pruned_at DESC LIMIT ?`
+
+	rows, err := db.Query(query, projectID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query pruned versions: %w", err)
+	}
+	defer rows.Close()
+
+	var records []*models.PrunedVersion
+	for rows.Next() {
+		pruned := &models.PrunedVersion{}
+		err := rows.Scan(
+			&pruned.ID,
+			&pruned.ProjectID,
+			&pruned.VersionID,
+			&pruned.Version,
+			&pruned.FileHash,
+			&pruned.FileName,
+			&pruned.FileSize,
+			&pruned.Reason,
+			&pruned.Trigger,
+			&pruned.CreatedAt,
+			&pruned.PrunedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan pruned version: %w", err)
+		}
+		records = append(records, pruned)
+	}
+
+	return records, rows.Err()
+}
@@ -0,0 +1,190 @@
+package database
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+)
+
+var (
+	// ErrProjectNotFound 项目不存在或已在回收站中
+	ErrProjectNotFound = errors.New("project not found")
+	// ErrNotInTrash 项目不在回收站中，或已经开始清理
+	ErrNotInTrash = errors.New("project is not in the trash")
+)
+
+// deletionColumns 查询删除记录时的列，顺序与 scanDeletion 一致
+const deletionColumns = `project_id, project_name, deleted_by, status, purge_after, total_versions, purged_versions,
+	error, deleted_at, updated_at, purged_at`
+
+// scanDeletion 扫描一行删除记录
+func scanDeletion(row rowScanner) (*models.ProjectDeletion, error) {
+	deletion := &models.ProjectDeletion{}
+	var purgedAt sql.NullTime
+	err := row.Scan(
+		&deletion.ProjectID,
+		&deletion.ProjectName,
+		&deletion.DeletedBy,
+		&deletion.Status,
+		&deletion.PurgeAfter,
+		&deletion.TotalVersions,
+		&deletion.PurgedVersions,
+		&deletion.Error,
+		&deletion.DeletedAt,
+		&deletion.UpdatedAt,
+		&purgedAt,
+	)
+	if purgedAt.Valid {
+		deletion.PurgedAt = &purgedAt.Time
+	}
+	return deletion, err
+}
+
+// TrashProject 将项目移入回收站，项目及其凭证立即不可用，purgeAfter 之前可以恢复
+func (db *DB) TrashProject(projectID, deletedBy string, purgeAfter time.Time) (*models.ProjectDeletion, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	deletion := &models.ProjectDeletion{
+		ProjectID:  projectID,
+		DeletedBy:  deletedBy,
+		Status:     models.DeletionTrashed,
+		PurgeAfter: purgeAfter,
+		DeletedAt:  now,
+		UpdatedAt:  now,
+	}
+	err = tx.QueryRow(`UPDATE projects SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL RETURNING name`,
+		now, projectID).Scan(&deletion.ProjectName)
+	if err == sql.ErrNoRows {
+		return nil, ErrProjectNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to trash project: %w", err)
+	}
+	err = tx.QueryRow(`SELECT COUNT(*) FROM database_versions WHERE project_id = ?`, projectID).Scan(&deletion.TotalVersions)
+	if err != nil {
+		return nil, fmt.Errorf("failed to count project versions: %w", err)
+	}
+
+	// 同一ID的项目此前被删除并清理过时覆盖旧记录
+	_, err = tx.Exec(`INSERT OR REPLACE INTO project_deletions (`+deletionColumns+`)
+			  VALUES (?, ?, ?, ?, ?, ?, 0, '', ?, ?, NULL)`,
+		deletion.ProjectID, deletion.ProjectName, deletion.DeletedBy, deletion.Status, deletion.PurgeAfter,
+		deletion.TotalVersions, now, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create project deletion: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return deletion, nil
+}
+
+// RestoreProject 从回收站恢复尚未开始清理的项目
+func (db *DB) RestoreProject(projectID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`DELETE FROM project_deletions WHERE project_id = ? AND status = ?`, projectID, models.DeletionTrashed)
+	if err != nil {
+		return fmt.Errorf("failed to delete project deletion: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to delete project deletion: %w", err)
+	} else if n == 0 {
+		return ErrNotInTrash
+	}
+	_, err = tx.Exec(`UPDATE projects SET deleted_at = NULL WHERE id = ?`, projectID)
+	if err != nil {
+		return fmt.Errorf("failed to restore project: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+// ListProjectDeletions 获取回收站中和清理中的项目，以及 since 之后清理完成的项目
+func (db *DB) ListProjectDeletions(since time.Time) ([]*models.ProjectDeletion, error) {
+	query := `SELECT ` + deletionColumns + ` FROM project_deletions
+			  WHERE status != ? OR purged_at >= ? ORDER BY deleted_at DESC`
+	return db.queryDeletions(query, models.DeletionPurged, since)
+}
+
+// ListDuePurges 获取需要清理的项目：已到清理时间的回收站项目，以及上次未完成清理的项目
+func (db *DB) ListDuePurges(now time.Time) ([]*models.ProjectDeletion, error) {
+	query := `SELECT ` + deletionColumns + ` FROM project_deletions
+			  WHERE status = ? OR (status = ? AND purge_after <= ?) ORDER BY deleted_at`
+	return db.queryDeletions(query, models.DeletionPurging, models.DeletionTrashed, now)
+}
+
+// queryDeletions 执行查询并读取删除记录
+func (db *DB) queryDeletions(query string, args ...interface{}) ([]*models.ProjectDeletion, error) {
+	rows, err := db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query project deletions: %w", err)
+	}
+	defer rows.Close()
+
+	var deletions []*models.ProjectDeletion
+	for rows.Next() {
+		deletion, err := scanDeletion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan project deletion: %w", err)
+		}
+		deletions = append(deletions, deletion)
+	}
+	return deletions, rows.Err()
+}
+
+// StartPurge 将回收站中的项目标记为清理中，之后不能再恢复。项目已被恢复时返回 false
+func (db *DB) StartPurge(projectID string) (bool, error) {
+	result, err := db.Exec(`UPDATE project_deletions SET status = ?, updated_at = ? WHERE project_id = ? AND status IN (?, ?)`,
+		models.DeletionPurging, time.Now(), projectID, models.DeletionTrashed, models.DeletionPurging)
+	if err != nil {
+		return false, fmt.Errorf("failed to start purge: %w", err)
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("failed to start purge: %w", err)
+	}
+	return n > 0, nil
+}
+
+// SetPurgeProgress 记录已清理的版本数和最近一次清理失败的原因
+func (db *DB) SetPurgeProgress(projectID string, purgedVersions int, purgeErr string) error {
+	_, err := db.Exec(`UPDATE project_deletions SET purged_versions = ?, error = ?, updated_at = ? WHERE project_id = ?`,
+		purgedVersions, purgeErr, time.Now(), projectID)
+	if err != nil {
+		return fmt.Errorf("failed to update purge progress: %w", err)
+	}
+	return nil
+}
+
+// PurgeProjectNow 将回收站中项目的清理时间提前到现在
+func (db *DB) PurgeProjectNow(projectID string) error {
+	now := time.Now()
+	result, err := db.Exec(`UPDATE project_deletions SET purge_after = ?, updated_at = ? WHERE project_id = ? AND status = ?`,
+		now, now, projectID, models.DeletionTrashed)
+	if err != nil {
+		return fmt.Errorf("failed to schedule purge: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to schedule purge: %w", err)
+	} else if n == 0 {
+		return ErrNotInTrash
+	}
+	return nil
+}
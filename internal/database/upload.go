@@ -0,0 +1,254 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+)
+
+var (
+	// ErrUploadNotPending 上传会话已完成、放弃或过期
+	ErrUploadNotPending = errors.New("upload session is not pending")
+	// ErrUploadTooLarge 会话中分片的总大小超过限制
+	ErrUploadTooLarge = errors.New("upload session too large")
+)
+
+// CreateUploadSession 创建分片上传会话
+func (db *DB) CreateUploadSession(session *models.UploadSession) error {
+	query := `INSERT INTO upload_sessions (id, project_id, credential_id, file_name, description, label, metadata, status, created_at, updated_at)
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	var metadata []byte
+	if len(session.Metadata) > 0 {
+		var err error
+		if metadata, err = json.Marshal(session.Metadata); err != nil {
+			return fmt.Errorf("failed to encode upload metadata: %w", err)
+		}
+	}
+
+	now := time.Now()
+	_, err := db.Exec(query, session.ID, session.ProjectID, session.CredentialID, session.FileName,
+		session.Description, session.Label, string(metadata), session.Status, now, now)
+	if err != nil {
+		return fmt.Errorf("failed to create upload session: %w", err)
+	}
+
+	session.CreatedAt = now
+	session.UpdatedAt = now
+	return nil
+}
+
+// GetUploadSession 获取分片上传会话
+func (db *DB) GetUploadSession(id string) (*models.UploadSession, error) {
+	query := `SELECT id, project_id, credential_id, file_name, description, label, metadata, status, version_id, created_at, updated_at
+			  FROM upload_sessions WHERE id = ?`
+
+	session := &models.UploadSession{}
+	var metadata string
+	err := db.QueryRow(query, id).Scan(
+		&session.ID,
+		&session.ProjectID,
+		&session.CredentialID,
+		&session.FileName,
+		&session.Description,
+		&session.Label,
+		&metadata,
+		&session.Status,
+		&session.VersionID,
+		&session.CreatedAt,
+		&session.UpdatedAt,
+	)
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get upload session: %w", err)
+	}
+	if metadata != "" {
+		if err := json.Unmarshal([]byte(metadata), &session.Metadata); err != nil {
+			return nil, fmt.Errorf("failed to decode upload metadata: %w", err)
+		}
+	}
+
+	return session, nil
+}
+
+// FinishUploadSession 结束未完成的分片上传会话并清除分片记录，会话已经结束时返回 ErrUploadNotPending
+func (db *DB) FinishUploadSession(id, status, versionID string) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE upload_sessions SET status = ?, version_id = ?, updated_at = ? WHERE id = ? AND status = ?`,
+		status, versionID, time.Now(), id, models.UploadStatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to update upload session: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to update upload session: %w", err)
+	} else if n == 0 {
+		return ErrUploadNotPending
+	}
+
+	_, err = tx.Exec(`DELETE FROM upload_parts WHERE session_id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("failed to delete upload parts: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return nil
+}
+
+// ListStaleUploadSessions 获取 before 之后没有更新过的未完成会话
+func (db *DB) ListStaleUploadSessions(before time.Time) ([]string, error) {
+	rows, err := db.Query(`SELECT id FROM upload_sessions WHERE status = ? AND updated_at < ?`,
+		models.UploadStatusPending, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload sessions: %w", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan upload session: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// ExpireUploadSession 将 before 之后仍没有更新的未完成会话标记为过期并清除分片记录，返回需要从存储中删除的分片；
+// 会话期间有新分片上传或已经结束时不做处理，返回 nil
+func (db *DB) ExpireUploadSession(id string, before time.Time) ([]*models.UploadPart, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE upload_sessions SET status = ?, updated_at = ? WHERE id = ? AND status = ? AND updated_at < ?`,
+		models.UploadStatusExpired, time.Now(), id, models.UploadStatusPending, before)
+	if err != nil {
+		return nil, fmt.Errorf("failed to expire upload session: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, fmt.Errorf("failed to expire upload session: %w", err)
+	} else if n == 0 {
+		return nil, nil
+	}
+
+	rows, err := tx.Query(`SELECT session_id, part_number, size, part_hash, oss_key, created_at
+			  FROM upload_parts WHERE session_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload parts: %w", err)
+	}
+	var parts []*models.UploadPart
+	for rows.Next() {
+		part := &models.UploadPart{}
+		if err := rows.Scan(&part.SessionID, &part.PartNumber, &part.Size, &part.PartHash, &part.OSSKey, &part.CreatedAt); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan upload part: %w", err)
+		}
+		parts = append(parts, part)
+	}
+	rows.Close()
+
+	_, err = tx.Exec(`DELETE FROM upload_parts WHERE session_id = ?`, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to delete upload parts: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return parts, nil
+}
+
+// SaveUploadPart 保存分片记录，同一分片号重复上传时覆盖。会话已经结束时返回 ErrUploadNotPending，
+// 加上该分片后会话的总大小超过 maxTotal 时返回 ErrUploadTooLarge
+func (db *DB) SaveUploadPart(part *models.UploadPart, maxTotal int64) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// 先更新会话取得写锁，同一会话并发上传的分片依次计算总大小
+	now := time.Now()
+	result, err := tx.Exec(`UPDATE upload_sessions SET updated_at = ? WHERE id = ? AND status = ?`,
+		now, part.SessionID, models.UploadStatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to update upload session: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to update upload session: %w", err)
+	} else if n == 0 {
+		return ErrUploadNotPending
+	}
+
+	var total int64
+	err = tx.QueryRow(`SELECT COALESCE(SUM(size), 0) FROM upload_parts WHERE session_id = ? AND part_number != ?`,
+		part.SessionID, part.PartNumber).Scan(&total)
+	if err != nil {
+		return fmt.Errorf("failed to get upload size: %w", err)
+	}
+	if total+part.Size > maxTotal {
+		return ErrUploadTooLarge
+	}
+
+	query := `INSERT OR REPLACE INTO upload_parts (session_id, part_number, size, part_hash, oss_key, created_at)
+			  VALUES (?, ?, ?, ?, ?, ?)`
+	_, err = tx.Exec(query, part.SessionID, part.PartNumber, part.Size, part.PartHash, part.OSSKey, now)
+	if err != nil {
+		return fmt.Errorf("failed to save upload part: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	part.CreatedAt = now
+	return nil
+}
+
+// ListUploadParts 获取会话的全部分片（按分片号排序）
+func (db *DB) ListUploadParts(sessionID string) ([]*models.UploadPart, error) {
+	query := `SELECT session_id, part_number, size, part_hash, oss_key, created_at
+			  FROM upload_parts WHERE session_id = ? ORDER BY part_number`
+
+	rows, err := db.Query(query, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query upload parts: %w", err)
+	}
+	defer rows.Close()
+
+	var parts []*models.UploadPart
+	for rows.Next() {
+		part := &models.UploadPart{}
+		err := rows.Scan(
+			&part.SessionID,
+			&part.PartNumber,
+			&part.Size,
+			&part.PartHash,
+			&part.OSSKey,
+			&part.CreatedAt,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan upload part: %w", err)
+		}
+		parts = append(parts, part)
+	}
+
+	return parts, nil
+}
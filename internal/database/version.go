@@ -8,7 +8,45 @@ import (
 	"chchma.com/cloudlite-sync/internal/models"
 )
 
-// CreateDatabaseVersion 创建数据库版本
+// versionColumns 查询版本时的列，顺序与 scanVersion 一致
+const versionColumns = `id, project_id, version, file_hash, sha256, file_name, file_size, stored_size, compression,
+	key_id, oss_key, description, is_latest, pinned, label, COALESCE(schema_version, 0), broken, created_at`
+
+// rowScanner 兼容 *sql.Row 和 *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanVersion 按 versionColumns 的顺序读取一行版本记录
+func scanVersion(row rowScanner) (*models.DatabaseVersion, error) {
+	version := &models.DatabaseVersion{}
+	err := row.Scan(
+		&version.ID,
+		&version.ProjectID,
+		&version.Version,
+		&version.FileHash,
+		&version.SHA256,
+		&version.FileName,
+		&version.FileSize,
+		&version.StoredSize,
+		&version.Compression,
+		&version.KeyID,
+		&version.OSSKey,
+		&version.Description,
+		&version.IsLatest,
+		&version.Pinned,
+		&version.Label,
+		&version.SchemaVersion,
+		&version.Broken,
+		&version.CreatedAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return version, nil
+}
+
+// CreateDatabaseVersion 创建数据库版本。共享对象的引用由调用方在写入存储时通过 RetainBlob 或 AcquireBlob 取得
 func (db *DB) CreateDatabaseVersion(version *models.DatabaseVersion) error {
 	// 开始事务
 	tx, err := db.Begin()
@@ -18,26 +56,58 @@ func (db *DB) CreateDatabaseVersion(version *models.DatabaseVersion) error {
 	defer tx.Rollback()
 
 	// 如果设置为最新版本，先取消其他版本的最新标记
+	var previousLatest string
 	if version.IsLatest {
+		previousLatest, err = latestVersionIDTx(tx, version.ProjectID)
+		if err != nil {
+			return err
+		}
 		_, err = tx.Exec(`UPDATE database_versions SET is_latest = 0 WHERE project_id = ?`, version.ProjectID)
 		if err != nil {
 			return fmt.Errorf("failed to update latest flags: %w", err)
 		}
 	}
 
+	// 同一秒内上传的版本号相同，追加序号保证项目内唯一
+	version.Version, err = uniqueVersionTx(tx, version.ProjectID, version.Version)
+	if err != nil {
+		return err
+	}
+
 	// 插入新版本
-	query := `INSERT INTO database_versions (id, project_id, version, file_hash, file_name, file_size, oss_key, description, is_latest, created_at) 
-			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	query := `INSERT INTO database_versions (id, project_id, version, file_hash, sha256, file_name, file_size, stored_size, compression, key_id, oss_key, description, is_latest, label, schema_version, created_at) 
+			  VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
 
 	now := time.Now()
-	_, err = tx.Exec(query, version.ID, version.ProjectID, version.Version, version.FileHash,
-		version.FileName, version.FileSize, version.OSSKey, version.Description, version.IsLatest, now)
+	_, err = tx.Exec(query, version.ID, version.ProjectID, version.Version, version.FileHash, version.SHA256,
+		version.FileName, version.FileSize, version.StoredSize, version.Compression, version.KeyID, version.OSSKey,
+		version.Description, version.IsLatest, version.Label, version.SchemaVersion, now)
+	if isUniqueViolation(err) && version.Label != "" {
+		return ErrLabelExists
+	}
 	if err != nil {
 		return fmt.Errorf("failed to create database version: %w", err)
 	}
+	if err := insertMetadataTx(tx, version.ID, version.Metadata); err != nil {
+		return err
+	}
 
 	version.CreatedAt = now
 
+	if version.IsLatest {
+		err = recordMoveTx(tx, &models.ChannelMove{
+			ProjectID:     version.ProjectID,
+			ChannelName:   models.LatestChannel,
+			Action:        models.ChannelActionUpload,
+			FromVersionID: previousLatest,
+			ToVersionID:   version.ID,
+			CreatedAt:     now,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	// 提交事务
 	if err = tx.Commit(); err != nil {
 		return fmt.Errorf("failed to commit transaction: %w", err)
@@ -48,23 +118,9 @@ func (db *DB) CreateDatabaseVersion(version *models.DatabaseVersion) error {
 
 // GetDatabaseVersion 获取数据库版本
 func (db *DB) GetDatabaseVersion(id string) (*models.DatabaseVersion, error) {
-	query := `SELECT id, project_id, version, file_hash, file_name, file_size, oss_key, description, is_latest, created_at 
-			  FROM database_versions WHERE id = ?`
-
-	version := &models.DatabaseVersion{}
-	err := db.QueryRow(query, id).Scan(
-		&version.ID,
-		&version.ProjectID,
-		&version.Version,
-		&version.FileHash,
-		&version.FileName,
-		&version.FileSize,
-		&version.OSSKey,
-		&version.Description,
-		&version.IsLatest,
-		&version.CreatedAt,
-	)
+	query := `SELECT ` + versionColumns + ` FROM database_versions WHERE id = ?`
 
+	version, err := scanVersion(db.QueryRow(query, id))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -77,23 +133,9 @@ func (db *DB) GetDatabaseVersion(id string) (*models.DatabaseVersion, error) {
 
 // GetLatestVersion 获取项目的最新版本
 func (db *DB) GetLatestVersion(projectID string) (*models.DatabaseVersion, error) {
-	query := `SELECT id, project_id, version, file_hash, file_name, file_size, oss_key, description, is_latest, created_at 
-			  FROM database_versions WHERE project_id = ? AND is_latest = 1`
-
-	version := &models.DatabaseVersion{}
-	err := db.QueryRow(query, projectID).Scan(
-		&version.ID,
-		&version.ProjectID,
-		&version.Version,
-		&version.FileHash,
-		&version.FileName,
-		&version.FileSize,
-		&version.OSSKey,
-		&version.Description,
-		&version.IsLatest,
-		&version.CreatedAt,
-	)
+	query := `SELECT ` + versionColumns + ` FROM database_versions WHERE project_id = ? AND is_latest = 1`
 
+	version, err := scanVersion(db.QueryRow(query, projectID))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -104,25 +146,35 @@ func (db *DB) GetLatestVersion(projectID string) (*models.DatabaseVersion, error
 	return version, nil
 }
 
-// GetVersionByHash 通过文件哈希获取版本
-func (db *DB) GetVersionByHash(projectID, fileHash string) (*models.DatabaseVersion, error) {
-	query := `SELECT id, project_id, version, file_hash, file_name, file_size, oss_key, description, is_latest, created_at 
-			  FROM database_versions WHERE project_id = ? AND file_hash = ?`
+// GetLatestCompatibleVersion 获取 schema 版本在 [minSchema, maxSchema] 内的最新版本，maxSchema 小于 0 表示不限上限。
+// 只在最新版本及更早创建的版本中查找，回滚或设为最新后不会返回比最新版本更新的版本。
+// 升级前上传、尚未补全 schema 版本的版本视为兼容
+func (db *DB) GetLatestCompatibleVersion(projectID string, minSchema, maxSchema int) (*models.DatabaseVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM database_versions
+			  WHERE project_id = ? AND (schema_version IS NULL OR (schema_version >= ? AND (? < 0 OR schema_version <= ?)))
+			  AND created_at <= (SELECT created_at FROM database_versions WHERE project_id = ? AND is_latest = 1)
+			  ORDER BY created_at DESC LIMIT 1`
 
-	version := &models.DatabaseVersion{}
-	err := db.QueryRow(query, projectID, fileHash).Scan(
-		&version.ID,
-		&version.ProjectID,
-		&version.Version,
-		&version.FileHash,
-		&version.FileName,
-		&version.FileSize,
-		&version.OSSKey,
-		&version.Description,
-		&version.IsLatest,
-		&version.CreatedAt,
-	)
+	version, err := scanVersion(db.QueryRow(query, projectID, minSchema, maxSchema, maxSchema, projectID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get compatible version: %w", err)
+	}
+
+	return version, nil
+}
+
+// GetVersionByHash 通过文件哈希获取版本，hash 可以是 MD5（file_hash）或 SHA-256
+func (db *DB) GetVersionByHash(projectID, hash string) (*models.DatabaseVersion, error) {
+	column := "file_hash"
+	if IsSHA256(hash) {
+		column = "sha256"
+	}
+	query := `SELECT ` + versionColumns + ` FROM database_versions WHERE project_id = ? AND ` + column + ` = ?`
 
+	version, err := scanVersion(db.QueryRow(query, projectID, hash))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, nil
@@ -133,6 +185,121 @@ func (db *DB) GetVersionByHash(projectID, fileHash string) (*models.DatabaseVers
 	return version, nil
 }
 
+// GetVersionByContent 获取内容相同的版本，用于上传去重。已有 SHA-256 的版本按 SHA-256 比较，
+// 尚未补算 SHA-256 的旧版本按 MD5 比较
+func (db *DB) GetVersionByContent(projectID, md5Hash, sha256Hash string) (*models.DatabaseVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM database_versions
+			  WHERE project_id = ? AND (sha256 = ? OR (sha256 = '' AND file_hash = ?))
+			  ORDER BY created_at LIMIT 1`
+
+	version, err := scanVersion(db.QueryRow(query, projectID, sha256Hash, md5Hash))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get version by content: %w", err)
+	}
+
+	return version, nil
+}
+
+// ListVersionsWithoutSHA256 获取尚未计算 SHA-256 的版本
+func (db *DB) ListVersionsWithoutSHA256() ([]*models.DatabaseVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM database_versions WHERE sha256 = '' OR sha256 IS NULL ORDER BY created_at`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*models.DatabaseVersion
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan database version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// SetVersionSHA256 记录版本的 SHA-256
+func (db *DB) SetVersionSHA256(id, sha256Hash string) error {
+	_, err := db.Exec(`UPDATE database_versions SET sha256 = ? WHERE id = ?`, sha256Hash, id)
+	if err != nil {
+		return fmt.Errorf("failed to update version sha256: %w", err)
+	}
+	return nil
+}
+
+// ListVersionsWithoutSchemaVersion 获取尚未记录 schema 版本的版本
+func (db *DB) ListVersionsWithoutSchemaVersion() ([]*models.DatabaseVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM database_versions WHERE schema_version IS NULL ORDER BY created_at`
+
+	rows, err := db.Query(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database versions: %w", err)
+	}
+	defer rows.Close()
+
+	var versions []*models.DatabaseVersion
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan database version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+	return versions, rows.Err()
+}
+
+// SetVersionSchemaVersion 记录版本的 schema 版本
+func (db *DB) SetVersionSchemaVersion(id string, schemaVersion int) error {
+	_, err := db.Exec(`UPDATE database_versions SET schema_version = ? WHERE id = ?`, schemaVersion, id)
+	if err != nil {
+		return fmt.Errorf("failed to update version schema version: %w", err)
+	}
+	return nil
+}
+
+// IsSHA256 判断 hash 是否为十六进制的 SHA-256，否则视为 MD5
+func IsSHA256(hash string) bool {
+	return len(hash) == 64
+}
+
+// GetVersionByLabel 通过版本标签获取版本
+func (db *DB) GetVersionByLabel(projectID, label string) (*models.DatabaseVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM database_versions WHERE project_id = ? AND label = ? AND label != ''`
+
+	version, err := scanVersion(db.QueryRow(query, projectID, label))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get version by label: %w", err)
+	}
+
+	return version, nil
+}
+
+// uniqueVersionTx 版本号在项目内已存在时依次追加 -1、-2 等序号
+func uniqueVersionTx(tx *sql.Tx, projectID, version string) (string, error) {
+	candidate := version
+	for i := 1; ; i++ {
+		var exists bool
+		err := tx.QueryRow(`SELECT EXISTS(SELECT 1 FROM database_versions WHERE project_id = ? AND version = ?)`,
+			projectID, candidate).Scan(&exists)
+		if err != nil {
+			return "", fmt.Errorf("failed to check version: %w", err)
+		}
+		if !exists {
+			return candidate, nil
+		}
+		candidate = fmt.Sprintf("%s-%d", version, i)
+	}
+}
+
 // ListDatabaseVersions 获取数据库版本列表（分页）
 func (db *DB) ListDatabaseVersions(projectID string, page, pageSize int) ([]*models.DatabaseVersion, int, error) {
 	// 获取总数
@@ -145,8 +312,7 @@ func (db *DB) ListDatabaseVersions(projectID string, page, pageSize int) ([]*mod
 
 	// 获取分页数据
 	offset := (page - 1) * pageSize
-	query := `SELECT id, project_id, version, file_hash, file_name, file_size, oss_key, description, is_latest, created_at 
-			  FROM database_versions WHERE project_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`
+	query := `SELECT ` + versionColumns + ` FROM database_versions WHERE project_id = ? ORDER BY created_at DESC LIMIT ? OFFSET ?`
 
 	rows, err := db.Query(query, projectID, pageSize, offset)
 	if err != nil {
@@ -156,19 +322,7 @@ func (db *DB) ListDatabaseVersions(projectID string, page, pageSize int) ([]*mod
 
 	var versions []*models.DatabaseVersion
 	for rows.Next() {
-		version := &models.DatabaseVersion{}
-		err := rows.Scan(
-			&version.ID,
-			&version.ProjectID,
-			&version.Version,
-			&version.FileHash,
-			&version.FileName,
-			&version.FileSize,
-			&version.OSSKey,
-			&version.Description,
-			&version.IsLatest,
-			&version.CreatedAt,
-		)
+		version, err := scanVersion(rows)
 		if err != nil {
 			return nil, 0, fmt.Errorf("failed to scan database version: %w", err)
 		}
@@ -178,27 +332,35 @@ func (db *DB) ListDatabaseVersions(projectID string, page, pageSize int) ([]*mod
 	return versions, total, nil
 }
 
-// DeleteDatabaseVersion 删除数据库版本
-func (db *DB) DeleteDatabaseVersion(id string) error {
+// DeleteDatabaseVersion 删除数据库版本并释放其存储对象的引用，返回存储对象是否已不再被引用、可以删除
+func (db *DB) DeleteDatabaseVersion(id string) (bool, error) {
 	// 开始事务
 	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
-	// 先获取版本信息，包括项目ID和是否是最新版本
-	var projectID string
+	// 先获取版本信息，包括项目ID、是否是最新版本和存储键
+	var projectID, ossKey string
 	var isLatest bool
-	err = tx.QueryRow(`SELECT project_id, is_latest FROM database_versions WHERE id = ?`, id).Scan(&projectID, &isLatest)
+	err = tx.QueryRow(`SELECT project_id, is_latest, oss_key FROM database_versions WHERE id = ?`, id).Scan(&projectID, &isLatest, &ossKey)
 	if err != nil {
-		return fmt.Errorf("failed to get version info: %w", err)
+		return false, fmt.Errorf("failed to get version info: %w", err)
 	}
 
-	// 删除版本
+	// 删除版本及其元数据
+	_, err = tx.Exec(`DELETE FROM version_metadata WHERE version_id = ?`, id)
+	if err != nil {
+		return false, fmt.Errorf("failed to delete version metadata: %w", err)
+	}
 	_, err = tx.Exec(`DELETE FROM database_versions WHERE id = ?`, id)
 	if err != nil {
-		return fmt.Errorf("failed to delete database version: %w", err)
+		return false, fmt.Errorf("failed to delete database version: %w", err)
+	}
+	unreferenced, err := releaseBlobTx(tx, ossKey)
+	if err != nil {
+		return false, err
 	}
 
 	// 如果删除的是最新版本，设置最近创建的版本为最新
@@ -207,7 +369,7 @@ func (db *DB) DeleteDatabaseVersion(id string) error {
 		var count int
 		err = tx.QueryRow(`SELECT COUNT(*) FROM database_versions WHERE project_id = ?`, projectID).Scan(&count)
 		if err != nil {
-			return fmt.Errorf("failed to count remaining versions: %w", err)
+			return false, fmt.Errorf("failed to count remaining versions: %w", err)
 		}
 
 		// 如果还有其他版本，设置最近创建的版本为最新
@@ -217,7 +379,21 @@ func (db *DB) DeleteDatabaseVersion(id string) error {
 							  WHERE project_id = ? 
 							  ORDER BY created_at DESC LIMIT 1)`, projectID)
 			if err != nil {
-				return fmt.Errorf("failed to update latest version: %w", err)
+				return false, fmt.Errorf("failed to update latest version: %w", err)
+			}
+			newLatest, err := latestVersionIDTx(tx, projectID)
+			if err != nil {
+				return false, err
+			}
+			err = recordMoveTx(tx, &models.ChannelMove{
+				ProjectID:     projectID,
+				ChannelName:   models.LatestChannel,
+				Action:        models.ChannelActionDelete,
+				FromVersionID: id,
+				ToVersionID:   newLatest,
+			})
+			if err != nil {
+				return false, err
 			}
 		}
 		// 如果没有其他版本，不需要设置最新版本（项目将没有最新版本）
@@ -225,37 +401,137 @@ func (db *DB) DeleteDatabaseVersion(id string) error {
 
 	// 提交事务
 	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+		return false, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
-	return nil
+	return unreferenced, nil
 }
 
-// SetLatestVersion 设置指定版本为最新版本
-func (db *DB) SetLatestVersion(projectID, versionID string) error {
+// SetLatestVersion 将项目的已有版本设为最新版本，并记录操作者
+func (db *DB) SetLatestVersion(projectID, versionID, movedBy string) (*models.ChannelMove, error) {
 	// 开始事务
 	tx, err := db.Begin()
 	if err != nil {
-		return fmt.Errorf("failed to begin transaction: %w", err)
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
 	}
 	defer tx.Rollback()
 
+	move, err := setLatestTx(tx, projectID, versionID, movedBy, models.ChannelActionPromote)
+	if err != nil {
+		return nil, err
+	}
+
+	// 提交事务
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return move, nil
+}
+
+// RollbackLatestVersion 将最新版本指回上一次变更之前的版本
+func (db *DB) RollbackLatestVersion(projectID, movedBy string) (*models.ChannelMove, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	currentID, err := latestVersionIDTx(tx, projectID)
+	if err != nil {
+		return nil, err
+	}
+	if currentID == "" {
+		return nil, ErrNoPreviousVersion
+	}
+	previousID, err := previousVersionTx(tx, projectID, models.LatestChannel, currentID)
+	if err != nil {
+		return nil, err
+	}
+
+	move, err := setLatestTx(tx, projectID, previousID, movedBy, models.ChannelActionRollback)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return move, nil
+}
+
+func setLatestTx(tx *sql.Tx, projectID, versionID, movedBy, action string) (*models.ChannelMove, error) {
+	previousID, err := latestVersionIDTx(tx, projectID)
+	if err != nil {
+		return nil, err
+	}
+
 	// 取消所有版本的最新标记
 	_, err = tx.Exec(`UPDATE database_versions SET is_latest = 0 WHERE project_id = ?`, projectID)
 	if err != nil {
-		return fmt.Errorf("failed to clear latest flags: %w", err)
+		return nil, fmt.Errorf("failed to clear latest flags: %w", err)
 	}
 
 	// 设置指定版本为最新
-	_, err = tx.Exec(`UPDATE database_versions SET is_latest = 1 WHERE id = ?`, versionID)
+	result, err := tx.Exec(`UPDATE database_versions SET is_latest = 1 WHERE id = ? AND project_id = ?`, versionID, projectID)
 	if err != nil {
-		return fmt.Errorf("failed to set latest version: %w", err)
+		return nil, fmt.Errorf("failed to set latest version: %w", err)
+	}
+	if n, err := result.RowsAffected(); err != nil || n == 0 {
+		return nil, fmt.Errorf("version %s not found in project %s", versionID, projectID)
 	}
 
-	// 提交事务
-	if err = tx.Commit(); err != nil {
-		return fmt.Errorf("failed to commit transaction: %w", err)
+	move := &models.ChannelMove{
+		ProjectID:     projectID,
+		ChannelName:   models.LatestChannel,
+		Action:        action,
+		FromVersionID: previousID,
+		ToVersionID:   versionID,
+		MovedBy:       movedBy,
+	}
+	if err := recordMoveTx(tx, move); err != nil {
+		return nil, err
+	}
+	return move, nil
+}
+
+// latestVersionIDTx 获取项目当前最新版本的ID，没有版本时为空
+func latestVersionIDTx(tx *sql.Tx, projectID string) (string, error) {
+	var id string
+	err := tx.QueryRow(`SELECT id FROM database_versions WHERE project_id = ? AND is_latest = 1`, projectID).Scan(&id)
+	if err != nil && err != sql.ErrNoRows {
+		return "", fmt.Errorf("failed to get latest version: %w", err)
+	}
+	return id, nil
+}
+
+// ListAllVersions 获取项目的全部版本，按创建时间从新到旧排列
+func (db *DB) ListAllVersions(projectID string) ([]*models.DatabaseVersion, error) {
+	query := `SELECT ` + versionColumns + ` FROM database_versions WHERE project_id = ? ORDER BY created_at DESC`
+
+	rows, err := db.Query(query, projectID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query database versions: %w", err)
 	}
+	defer rows.Close()
 
+	var versions []*models.DatabaseVersion
+	for rows.Next() {
+		version, err := scanVersion(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan database version: %w", err)
+		}
+		versions = append(versions, version)
+	}
+
+	return versions, rows.Err()
+}
+
+// SetVersionPinned 固定或取消固定版本
+func (db *DB) SetVersionPinned(id string, pinned bool) error {
+	_, err := db.Exec(`UPDATE database_versions SET pinned = ? WHERE id = ?`, pinned, id)
+	if err != nil {
+		return fmt.Errorf("failed to update version pin: %w", err)
+	}
 	return nil
 }
@@ -0,0 +1,174 @@
+// Package delta 实现数据库文件版本之间的页级差量。
+//
+// SQLite 文件由固定大小的页组成，两次上传之间通常只有少量页发生变化。
+// 差量按目标文件的页大小逐页比较源文件和目标文件，只记录内容不同的页：
+//
+//	magic      [8]byte  "CLSDELTA"
+//	version    uint8    格式版本，当前为 1
+//	pageSize   uint32   页大小
+//	sourceSize uint64   源文件大小
+//	targetSize uint64   目标文件大小
+//	之后重复：pageIndex uint32 + 页内容（最后一页可能不足 pageSize）
+//
+// 所有整数均为大端序。客户端应用差量时先将本地文件截断或扩展到 targetSize，
+// 再把每个页写入 pageIndex*pageSize 处。
+package delta
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	formatVersion   = 1
+	defaultPageSize = 4096
+	headerSize      = 8 + 1 + 4 + 8 + 8
+)
+
+var magic = []byte("CLSDELTA")
+
+// sqliteHeader SQLite 文件头的魔数
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// ErrInvalidDelta 差量数据格式不正确
+var ErrInvalidDelta = errors.New("delta: invalid delta data")
+
+// Stats 差量统计信息
+type Stats struct {
+	PageSize     int   `json:"page_size"`
+	ChangedPages int   `json:"changed_pages"`
+	TotalPages   int   `json:"total_pages"`
+	SourceSize   int64 `json:"source_size"`
+	TargetSize   int64 `json:"target_size"`
+}
+
+// PageSize 从 SQLite 文件头读取页大小，不是 SQLite 文件时返回默认块大小
+func PageSize(header []byte) int {
+	if len(header) < 18 || !bytes.Equal(header[:16], sqliteHeader) {
+		return defaultPageSize
+	}
+	size := int(binary.BigEndian.Uint16(header[16:18]))
+	if size == 1 {
+		return 65536
+	}
+	if size < 512 || size&(size-1) != 0 {
+		return defaultPageSize
+	}
+	return size
+}
+
+// Compute 顺序读取源文件和目标文件，将差量写入 w
+func Compute(w io.Writer, source io.Reader, sourceSize int64, target io.Reader, targetSize int64) (*Stats, error) {
+	tr := bufio.NewReader(target)
+	header, _ := tr.Peek(100)
+	pageSize := PageSize(header)
+
+	bw := bufio.NewWriter(w)
+	hdr := make([]byte, headerSize)
+	copy(hdr, magic)
+	hdr[8] = formatVersion
+	binary.BigEndian.PutUint32(hdr[9:13], uint32(pageSize))
+	binary.BigEndian.PutUint64(hdr[13:21], uint64(sourceSize))
+	binary.BigEndian.PutUint64(hdr[21:29], uint64(targetSize))
+	if _, err := bw.Write(hdr); err != nil {
+		return nil, err
+	}
+
+	stats := &Stats{PageSize: pageSize, SourceSize: sourceSize, TargetSize: targetSize}
+	sr := bufio.NewReader(source)
+	sourcePage := make([]byte, pageSize)
+	targetPage := make([]byte, pageSize)
+	index := make([]byte, 4)
+	sourceDone := false
+
+	for page := uint32(0); ; page++ {
+		n, err := io.ReadFull(tr, targetPage)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return nil, fmt.Errorf("failed to read target: %w", err)
+		}
+		stats.TotalPages++
+
+		m := 0
+		if !sourceDone {
+			m, err = io.ReadFull(sr, sourcePage)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				sourceDone = true
+			} else if err != nil {
+				return nil, fmt.Errorf("failed to read source: %w", err)
+			}
+		}
+
+		if m == n && bytes.Equal(sourcePage[:m], targetPage[:n]) {
+			continue
+		}
+		binary.BigEndian.PutUint32(index, page)
+		if _, err := bw.Write(index); err != nil {
+			return nil, err
+		}
+		if _, err := bw.Write(targetPage[:n]); err != nil {
+			return nil, err
+		}
+		stats.ChangedPages++
+	}
+
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// Apply 将差量应用到本地文件 f（内容应与生成差量时的源文件一致）
+func Apply(f *os.File, delta io.Reader) error {
+	br := bufio.NewReader(delta)
+	hdr := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, hdr); err != nil || !bytes.Equal(hdr[:8], magic) || hdr[8] != formatVersion {
+		return ErrInvalidDelta
+	}
+	pageSize := int64(binary.BigEndian.Uint32(hdr[9:13]))
+	sourceSize := int64(binary.BigEndian.Uint64(hdr[13:21]))
+	targetSize := int64(binary.BigEndian.Uint64(hdr[21:29]))
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() != sourceSize {
+		return fmt.Errorf("delta: base file size %d does not match source size %d", fi.Size(), sourceSize)
+	}
+	if err := f.Truncate(targetSize); err != nil {
+		return err
+	}
+
+	index := make([]byte, 4)
+	page := make([]byte, pageSize)
+	for {
+		if _, err := io.ReadFull(br, index); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return ErrInvalidDelta
+		}
+		offset := int64(binary.BigEndian.Uint32(index)) * pageSize
+		n := pageSize
+		if offset+n > targetSize {
+			n = targetSize - offset
+		}
+		if n <= 0 {
+			return ErrInvalidDelta
+		}
+		if _, err := io.ReadFull(br, page[:n]); err != nil {
+			return ErrInvalidDelta
+		}
+		if _, err := f.WriteAt(page[:n], offset); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,283 @@
+// Package envelope 实现数据库版本的信封加密。
+//
+// 每个项目有一个随机生成的数据密钥，用配置中的主密钥以 AES-GCM 包装后保存在数据库中；
+// 版本内容用数据密钥分块加密后写入存储。轮换主密钥时只需重新包装数据密钥，不必重新加密文件。
+//
+// 密文格式：
+//
+//	magic  [8]byte   "CLSENC1\x00"
+//	nonce  [12]byte  随机基础 nonce
+//	之后为若干个分块，每块为明文最多 64KiB 经 AES-256-GCM 加密的结果（附带 16 字节认证标签）
+//
+// 第 i 块的 nonce 为基础 nonce 的后 8 字节与 i 异或，附加数据标记是否为最后一块，
+// 因此分块被重排、截断或拼接都会导致解密失败。
+package envelope
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// KeySize 主密钥和数据密钥的长度（AES-256）
+	KeySize   = 32
+	chunkSize = 64 * 1024
+	nonceSize = 12
+	tagSize   = 16
+)
+
+var magic = []byte("CLSENC1\x00")
+
+var headerSize = int64(len(magic) + nonceSize)
+
+// ErrDecrypt 密钥不正确或密文被篡改
+var ErrDecrypt = errors.New("envelope: message authentication failed")
+
+// GenerateKey 生成随机数据密钥
+func GenerateKey() ([]byte, error) {
+	key := make([]byte, KeySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("envelope: key must be %d bytes", KeySize)
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Wrap 用主密钥包装数据密钥，结果为 nonce + 密文
+func Wrap(masterKey, dataKey []byte) ([]byte, error) {
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, dataKey, nil), nil
+}
+
+// Unwrap 用主密钥解开数据密钥
+func Unwrap(masterKey, wrapped []byte) ([]byte, error) {
+	gcm, err := newGCM(masterKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < nonceSize+tagSize {
+		return nil, ErrDecrypt
+	}
+	dataKey, err := gcm.Open(nil, wrapped[:nonceSize], wrapped[nonceSize:], nil)
+	if err != nil {
+		return nil, ErrDecrypt
+	}
+	return dataKey, nil
+}
+
+// chunkNonce 计算第 index 块的 nonce
+func chunkNonce(base []byte, index uint64) []byte {
+	nonce := make([]byte, nonceSize)
+	copy(nonce, base)
+	counter := binary.BigEndian.Uint64(nonce[4:]) ^ index
+	binary.BigEndian.PutUint64(nonce[4:], counter)
+	return nonce
+}
+
+func chunkAAD(final bool) []byte {
+	if final {
+		return []byte{1}
+	}
+	return []byte{0}
+}
+
+// Encrypt 读取 src 的全部内容，加密后写入 dst
+func Encrypt(dst io.Writer, src io.Reader, key []byte) error {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	base := make([]byte, nonceSize)
+	if _, err := rand.Read(base); err != nil {
+		return err
+	}
+	if _, err := dst.Write(append(append([]byte{}, magic...), base...)); err != nil {
+		return err
+	}
+
+	// 预读下一块以判断当前块是否为最后一块
+	br := bufio.NewReaderSize(src, chunkSize+1)
+	plain := make([]byte, chunkSize)
+	sealed := make([]byte, 0, chunkSize+tagSize)
+	for index := uint64(0); ; index++ {
+		n, err := io.ReadFull(br, plain)
+		if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		final := n < chunkSize
+		if !final {
+			if _, err := br.Peek(1); err == io.EOF {
+				final = true
+			}
+		}
+		sealed = gcm.Seal(sealed[:0], chunkNonce(base, index), plain[:n], chunkAAD(final))
+		if _, err := dst.Write(sealed); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+	}
+}
+
+// NewReader 返回解密 src 的读取器，密文被篡改或截断时 Read 返回 ErrDecrypt
+func NewReader(src io.Reader, key []byte) (io.Reader, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReaderSize(src, chunkSize+tagSize+1)
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, header); err != nil || !bytes.Equal(header[:len(magic)], magic) {
+		return nil, ErrDecrypt
+	}
+	return &reader{
+		gcm:    gcm,
+		src:    br,
+		base:   header[len(magic):],
+		sealed: make([]byte, chunkSize+tagSize),
+	}, nil
+}
+
+type reader struct {
+	gcm    cipher.AEAD
+	src    *bufio.Reader
+	base   []byte
+	index  uint64
+	sealed []byte
+	plain  []byte
+	done   bool
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		n, err := io.ReadFull(r.src, r.sealed)
+		if err != nil && err != io.ErrUnexpectedEOF {
+			if err == io.EOF {
+				return 0, ErrDecrypt // 缺少最后一块
+			}
+			return 0, err
+		}
+		final := n < len(r.sealed)
+		if !final {
+			if _, err := r.src.Peek(1); err == io.EOF {
+				final = true
+			}
+		}
+		plain, err := r.gcm.Open(r.sealed[:0], chunkNonce(r.base, r.index), r.sealed[:n], chunkAAD(final))
+		if err != nil {
+			return 0, ErrDecrypt
+		}
+		r.plain = plain
+		r.index++
+		r.done = final
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
+
+// PlainSize 根据密文长度计算明文长度
+func PlainSize(encryptedSize int64) int64 {
+	body := encryptedSize - headerSize
+	if body < tagSize {
+		return 0
+	}
+	full := body / (chunkSize + tagSize)
+	rest := body % (chunkSize + tagSize)
+	if rest < tagSize {
+		return full * chunkSize
+	}
+	return full*chunkSize + rest - tagSize
+}
+
+// Keyring 配置中的主密钥集合，新数据密钥总是用当前主密钥包装
+type Keyring struct {
+	keys   map[string][]byte
+	active string
+}
+
+// NewKeyring 解析 base64 编码的主密钥，未配置主密钥时返回未启用加密的 Keyring。
+// active 为空且只有一个主密钥时使用该密钥。
+func NewKeyring(masterKeys map[string]string, active string) (*Keyring, error) {
+	k := &Keyring{keys: make(map[string][]byte), active: active}
+	for id, encoded := range masterKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("master key %s is not valid base64: %w", id, err)
+		}
+		if len(key) != KeySize {
+			return nil, fmt.Errorf("master key %s must be %d bytes", id, KeySize)
+		}
+		k.keys[id] = key
+	}
+	if len(k.keys) == 0 {
+		k.active = ""
+		return k, nil
+	}
+	if k.active == "" && len(k.keys) == 1 {
+		for id := range k.keys {
+			k.active = id
+		}
+	}
+	if _, ok := k.keys[k.active]; !ok {
+		return nil, fmt.Errorf("active master key %q is not configured", k.active)
+	}
+	return k, nil
+}
+
+// Enabled 是否启用加密
+func (k *Keyring) Enabled() bool {
+	return k != nil && k.active != ""
+}
+
+// ActiveID 当前主密钥ID
+func (k *Keyring) ActiveID() string {
+	return k.active
+}
+
+// Wrap 用当前主密钥包装数据密钥
+func (k *Keyring) Wrap(dataKey []byte) (masterKeyID string, wrapped []byte, err error) {
+	if !k.Enabled() {
+		return "", nil, errors.New("envelope: encryption is not enabled")
+	}
+	wrapped, err = Wrap(k.keys[k.active], dataKey)
+	return k.active, wrapped, err
+}
+
+// Unwrap 用指定主密钥解开数据密钥
+func (k *Keyring) Unwrap(masterKeyID string, wrapped []byte) ([]byte, error) {
+	masterKey, ok := k.keys[masterKeyID]
+	if !ok {
+		return nil, fmt.Errorf("envelope: master key %q is not configured", masterKeyID)
+	}
+	return Unwrap(masterKey, wrapped)
+}
@@ -0,0 +1,106 @@
+// Package idgen 使用 crypto/rand 生成凭证令牌、项目ID和分享码等随机字符串。
+package idgen
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+)
+
+// 常用字符集
+const (
+	Upper        = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Digits       = "0123456789"
+	UpperDigits  = Upper + Digits
+	Alphanumeric = Upper + "abcdefghijklmnopqrstuvwxyz" + Digits
+)
+
+// MaxAttempts 写入时因冲突重新生成的最多尝试次数
+const MaxAttempts = 5
+
+// ErrCollision 生成的值与已有的值重复。写入函数返回包装了该错误的错误时 Insert 会重新生成
+var ErrCollision = errors.New("generated value already exists")
+
+// 内置生成器
+var (
+	// ProjectIDs 项目ID：8 位大写字母
+	ProjectIDs = MustNew(Upper, 8)
+	// ShareCodes 分享码：6 位数字
+	ShareCodes = MustNew(Digits, 6)
+	// JWTTokenIDs JWT 令牌记录ID：12 位大写字母和数字
+	JWTTokenIDs = MustNew(UpperDigits, 12)
+)
+
+// Generator 从固定字符集中均匀随机地选取字符，生成固定长度的字符串
+type Generator struct {
+	alphabet string
+	length   int
+}
+
+// New 创建生成器，字符集至少包含 2 个不重复的 ASCII 字符
+func New(alphabet string, length int) (*Generator, error) {
+	if length <= 0 {
+		return nil, fmt.Errorf("length must be positive, got %d", length)
+	}
+	if len(alphabet) < 2 {
+		return nil, errors.New("alphabet must contain at least 2 characters")
+	}
+	seen := make(map[rune]bool, len(alphabet))
+	for _, c := range alphabet {
+		if c > 0x7e || c < 0x21 {
+			return nil, fmt.Errorf("alphabet must contain only printable ASCII characters, got %q", c)
+		}
+		if seen[c] {
+			return nil, fmt.Errorf("alphabet contains duplicate character %q", c)
+		}
+		seen[c] = true
+	}
+	return &Generator{alphabet: alphabet, length: length}, nil
+}
+
+// MustNew 创建生成器，参数无效时 panic，用于包级变量
+func MustNew(alphabet string, length int) *Generator {
+	g, err := New(alphabet, length)
+	if err != nil {
+		panic(err)
+	}
+	return g
+}
+
+// Length 生成的字符串长度
+func (g *Generator) Length() int {
+	return g.length
+}
+
+// Generate 生成随机字符串。丢弃超出字符集整数倍范围的随机字节，避免取模带来的偏差
+func (g *Generator) Generate() string {
+	n := len(g.alphabet)
+	limit := 256 - 256%n
+	out := make([]byte, 0, g.length)
+	buf := make([]byte, g.length+g.length/2)
+	for len(out) < g.length {
+		// Go 1.24 起 rand.Read 不会返回错误
+		rand.Read(buf)
+		for _, b := range buf {
+			if int(b) >= limit {
+				continue
+			}
+			out = append(out, g.alphabet[int(b)%n])
+			if len(out) == g.length {
+				break
+			}
+		}
+	}
+	return string(out)
+}
+
+// Insert 生成值并交给 insert 写入，insert 返回 ErrCollision 时重新生成，最多尝试 MaxAttempts 次
+func (g *Generator) Insert(insert func(value string) error) error {
+	var err error
+	for i := 0; i < MaxAttempts; i++ {
+		if err = insert(g.Generate()); !errors.Is(err, ErrCollision) {
+			return err
+		}
+	}
+	return fmt.Errorf("giving up after %d attempts: %w", MaxAttempts, err)
+}
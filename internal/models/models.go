@@ -1,41 +1,302 @@
 package models
 
 import (
+	"strings"
 	"time"
 )
 
+// 下载模式
+const (
+	DownloadModeProxy    = "proxy"    // 由服务器中转文件内容
+	DownloadModeRedirect = "redirect" // 302 跳转到存储后端的预签名地址
+	DownloadModeURL      = "url"      // 返回包含预签名地址的JSON
+)
+
 // Project 项目模型
 type Project struct {
-	ID          string    `json:"id" db:"id"`
-	Name        string    `json:"name" db:"name"`
-	Description string    `json:"description" db:"description"`
-	Website     string    `json:"website" db:"website"`
+	ID           string `json:"id" db:"id"`
+	Name         string `json:"name" db:"name"`
+	Description  string `json:"description" db:"description"`
+	Website      string `json:"website" db:"website"`
+	DownloadMode string `json:"download_mode" db:"download_mode"`
+	// RequiredTables 上传的数据库必须包含的表，逗号分隔
+	RequiredTables string `json:"required_tables" db:"required_tables"`
+	// RetainCount 保留最近的版本数，RetainDays 保留最近天数内的版本，均为 0 时不自动清理
+	RetainCount int       `json:"retain_count" db:"retain_count"`
+	RetainDays  int       `json:"retain_days" db:"retain_days"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
 }
 
+// 项目删除的状态
+const (
+	DeletionTrashed = "trashed" // 在回收站中，清理前可以恢复
+	DeletionPurging = "purging" // 正在清理版本、凭证和存储对象
+	DeletionPurged  = "purged"  // 已清理完成
+)
+
+// ProjectDeletion 项目的删除记录：删除的项目先移入回收站，到期后由后台任务清理全部数据
+type ProjectDeletion struct {
+	ProjectID      string     `json:"project_id" db:"project_id"`
+	ProjectName    string     `json:"project_name" db:"project_name"`
+	DeletedBy      string     `json:"deleted_by" db:"deleted_by"`
+	Status         string     `json:"status" db:"status"`
+	PurgeAfter     time.Time  `json:"purge_after" db:"purge_after"` // 在此之前可以恢复
+	TotalVersions  int        `json:"total_versions" db:"total_versions"`
+	PurgedVersions int        `json:"purged_versions" db:"purged_versions"`
+	Error          string     `json:"error" db:"error"` // 最近一次清理失败的原因，下次执行时重试
+	DeletedAt      time.Time  `json:"deleted_at" db:"deleted_at"`
+	UpdatedAt      time.Time  `json:"updated_at" db:"updated_at"`
+	PurgedAt       *time.Time `json:"purged_at" db:"purged_at"`
+}
+
+// Progress 已清理版本的百分比
+func (d *ProjectDeletion) Progress() int {
+	switch {
+	case d.Status == DeletionPurged:
+		return 100
+	case d.TotalVersions == 0:
+		return 0
+	}
+	return d.PurgedVersions * 100 / d.TotalVersions
+}
+
 // Credential 凭证模型
 type Credential struct {
-	ID        string    `json:"id" db:"id"`
-	ProjectID string    `json:"project_id" db:"project_id"`
-	Token     string    `json:"token" db:"token"`
-	IsActive  bool      `json:"is_active" db:"is_active"`
-	CreatedAt time.Time `json:"created_at" db:"created_at"`
-	UpdatedAt time.Time `json:"updated_at" db:"updated_at"`
+	ID        string `json:"id" db:"id"`
+	ProjectID string `json:"project_id" db:"project_id"`
+	// Token 明文令牌，只在创建时返回一次，数据库中只保存加盐哈希
+	Token       string `json:"token,omitempty"`
+	TokenPrefix string `json:"token_prefix" db:"token_prefix"` // 令牌的前几位，用于识别凭证和查找
+	TokenHash   string `json:"-" db:"token"`
+	TokenSalt   string `json:"-" db:"token_salt"`
+	IsActive    bool   `json:"is_active" db:"is_active"`
+	// Scopes 凭证的权限，逗号分隔，取值见 CredentialScopes
+	Scopes      string `json:"scopes" db:"scopes"`
+	Name        string `json:"name" db:"name"` // 便于识别凭证归属的名称，如设备名
+	Description string `json:"description" db:"description"`
+	// ExpiresAt 过期时间，为空表示永不过期
+	ExpiresAt *time.Time `json:"expires_at,omitempty" db:"expires_at"`
+	// AllowedCIDRs 允许调用 API 的客户端地址段，逗号分隔，为空表示不限制
+	AllowedCIDRs string     `json:"allowed_cidrs" db:"allowed_cidrs"`
+	LastUsedAt   *time.Time `json:"last_used_at,omitempty" db:"last_used_at"` // 最近一次成功调用 API 的时间
+	LastUsedIP   string     `json:"last_used_ip" db:"last_used_ip"`
+	CreatedAt    time.Time  `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at" db:"updated_at"`
+}
+
+// Expired 凭证是否已过期
+func (c *Credential) Expired(now time.Time) bool {
+	return c.ExpiresAt != nil && !now.Before(*c.ExpiresAt)
+}
+
+// 凭证权限
+const (
+	ScopeRead    = "read"    // 下载版本文件、增量和对比，查询版本信息
+	ScopeWrite   = "write"   // 上传新版本（含分片上传）
+	ScopePromote = "promote" // 设为最新、回滚，移动和回滚渠道
+	ScopeList    = "list"    // 列出版本、渠道及变更记录，预览清理
+)
+
+// CredentialScopes 全部凭证权限，按此顺序保存和显示
+var CredentialScopes = []string{ScopeRead, ScopeWrite, ScopePromote, ScopeList}
+
+// HasScope 凭证是否具有指定权限
+func (c *Credential) HasScope(scope string) bool {
+	for _, s := range strings.Split(c.Scopes, ",") {
+		if strings.TrimSpace(s) == scope {
+			return true
+		}
+	}
+	return false
 }
 
 // DatabaseVersion 数据库版本模型
 type DatabaseVersion struct {
+	ID        string `json:"id" db:"id"`
+	ProjectID string `json:"project_id" db:"project_id"`
+	Version   string `json:"version" db:"version"`
+	FileHash  string `json:"file_hash" db:"file_hash"`
+	// SHA256 文件内容的 SHA-256（十六进制），用于完整性校验；升级前的版本在后台补算完成前为空
+	SHA256   string `json:"sha256" db:"sha256"`
+	FileName string `json:"file_name" db:"file_name"`
+	FileSize int64  `json:"file_size" db:"file_size"`
+	// StoredSize 存储后端中对象的大小，启用压缩时小于 FileSize
+	StoredSize  int64  `json:"stored_size" db:"stored_size"`
+	Compression string `json:"compression" db:"compression"` // 存储时使用的压缩算法，为空表示未压缩
+	KeyID       string `json:"key_id" db:"key_id"`           // 加密使用的项目数据密钥ID，为空表示未加密
+	OSSKey      string `json:"oss_key" db:"oss_key"`
+	Description string `json:"description" db:"description"`
+	IsLatest    bool   `json:"is_latest" db:"is_latest"`
+	Pinned      bool   `json:"pinned" db:"pinned"` // 固定的版本不会被保留策略清理
+	Label       string `json:"label" db:"label"`   // 客户端指定的版本标签（如 1.4.0），项目内唯一，为空表示未设置
+	// SchemaVersion 上传时从文件头读取的 PRAGMA user_version，用于按客户端支持的 schema 范围选择版本
+	SchemaVersion int `json:"schema_version" db:"schema_version"`
+	// Broken 存储一致性检查发现的问题（missing、size_mismatch、hash_mismatch），为空表示正常
+	Broken    string    `json:"broken,omitempty" db:"broken"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	// Metadata 上传时附带的键值信息（如 app_build、schema_version、source_host），保存在 version_metadata 表
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// 渠道变更类型
+const (
+	ChannelActionCreate   = "create"
+	ChannelActionMove     = "move"
+	ChannelActionRollback = "rollback"
+	// 以下只用于最新版本指针
+	ChannelActionUpload  = "upload"  // 上传新版本
+	ChannelActionPromote = "promote" // 将已有版本设为最新
+	ChannelActionDelete  = "delete"  // 删除最新版本后自动指向上一个版本
+)
+
+// LatestChannel 最新版本指针（is_latest）的变更记录与渠道记录保存在一起，使用这个保留名称
+const LatestChannel = "latest"
+
+// Channel 项目的发布渠道（如 stable、beta、canary），指向某个版本
+type Channel struct {
+	ID        string           `json:"id" db:"id"`
+	ProjectID string           `json:"project_id" db:"project_id"`
+	Name      string           `json:"name" db:"name"`
+	VersionID string           `json:"version_id" db:"version_id"`
+	UpdatedBy string           `json:"updated_by" db:"updated_by"`
+	CreatedAt time.Time        `json:"created_at" db:"created_at"`
+	UpdatedAt time.Time        `json:"updated_at" db:"updated_at"`
+	Version   *DatabaseVersion `json:"version,omitempty"` // 渠道当前指向的版本
+}
+
+// ChannelMove 渠道的一次变更记录
+type ChannelMove struct {
+	ID            string    `json:"id" db:"id"`
+	ProjectID     string    `json:"project_id" db:"project_id"`
+	ChannelName   string    `json:"channel" db:"channel_name"`
+	Action        string    `json:"action" db:"action"`
+	FromVersionID string    `json:"from_version_id" db:"from_version_id"` // 创建渠道时为空
+	ToVersionID   string    `json:"to_version_id" db:"to_version_id"`
+	MovedBy       string    `json:"moved_by" db:"moved_by"` // 管理员用户名，或 credential:凭证ID
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+	// 变更前后版本的版本号和哈希，版本已删除时为空
+	FromVersion string `json:"from_version"`
+	FromHash    string `json:"from_hash"`
+	ToVersion   string `json:"to_version"`
+	ToHash      string `json:"to_hash"`
+}
+
+// Blob 按内容 SHA-256 存储的共享对象，内容相同的版本（包括不同项目的版本）引用同一个对象
+type Blob struct {
+	SHA256      string    `json:"sha256" db:"sha256"`
+	OSSKey      string    `json:"oss_key" db:"oss_key"`
+	FileSize    int64     `json:"file_size" db:"file_size"`
+	StoredSize  int64     `json:"stored_size" db:"stored_size"`
+	Compression string    `json:"compression" db:"compression"`
+	RefCount    int       `json:"ref_count" db:"ref_count"` // 引用该对象的版本数，降为 0 时删除
+	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// 存储一致性检查发现的问题类型
+const (
+	IssueOrphan       = "orphan"        // 存储中没有任何记录引用的对象
+	IssueMissing      = "missing"       // 记录引用的对象在存储中不存在
+	IssueSizeMismatch = "size_mismatch" // 对象大小与记录的存储大小不一致
+	IssueHashMismatch = "hash_mismatch" // 对象内容与记录的哈希或文件大小不一致
+	IssueRefCount     = "ref_count"     // 共享对象的引用计数与实际引用的版本数不一致
+)
+
+// ReconcileIssue 存储一致性检查发现的一个问题
+type ReconcileIssue struct {
+	Kind      string `json:"kind"`
+	OSSKey    string `json:"oss_key"`
+	ProjectID string `json:"project_id,omitempty"`
+	VersionID string `json:"version_id,omitempty"` // 版本或差量的ID
+	Detail    string `json:"detail,omitempty"`
+	Repaired  bool   `json:"repaired"`
+	Error     string `json:"error,omitempty"` // 修复失败的原因
+}
+
+// ReconcileReport 一次存储一致性检查的结果
+type ReconcileReport struct {
+	Repair          bool              `json:"repair"`
+	VerifyHash      bool              `json:"verify_hash"`
+	ObjectsScanned  int               `json:"objects_scanned"`
+	VersionsChecked int               `json:"versions_checked"`
+	Issues          []*ReconcileIssue `json:"issues"`
+	Errors          []string          `json:"errors,omitempty"` // 无法完成检查的对象
+	StartedAt       time.Time         `json:"started_at"`
+	FinishedAt      time.Time         `json:"finished_at"`
+}
+
+// PrunedVersion 被保留策略清理的版本记录
+type PrunedVersion struct {
+	ID        string    `json:"id" db:"id"`
+	ProjectID string    `json:"project_id" db:"project_id"`
+	VersionID string    `json:"version_id" db:"version_id"`
+	Version   string    `json:"version" db:"version"`
+	FileHash  string    `json:"file_hash" db:"file_hash"`
+	FileName  string    `json:"file_name" db:"file_name"`
+	FileSize  int64     `json:"file_size" db:"file_size"`
+	Reason    string    `json:"reason" db:"reason"`
+	Trigger   string    `json:"trigger" db:"trigger"`       // scheduled（后台任务）或 manual（管理员手动执行）
+	CreatedAt time.Time `json:"created_at" db:"created_at"` // 版本的创建时间
+	PrunedAt  time.Time `json:"pruned_at" db:"pruned_at"`
+}
+
+// ProjectKey 项目的数据密钥，以主密钥包装后保存
+type ProjectKey struct {
 	ID          string    `json:"id" db:"id"`
 	ProjectID   string    `json:"project_id" db:"project_id"`
-	Version     string    `json:"version" db:"version"`
-	FileHash    string    `json:"file_hash" db:"file_hash"`
-	FileName    string    `json:"file_name" db:"file_name"`
-	FileSize    int64     `json:"file_size" db:"file_size"`
-	OSSKey      string    `json:"oss_key" db:"oss_key"`
-	Description string    `json:"description" db:"description"`
-	IsLatest    bool      `json:"is_latest" db:"is_latest"`
+	MasterKeyID string    `json:"master_key_id" db:"master_key_id"`
+	WrappedKey  []byte    `json:"-" db:"wrapped_key"`
 	CreatedAt   time.Time `json:"created_at" db:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at" db:"updated_at"`
+}
+
+// VersionDelta 两个版本之间缓存的页级差量
+type VersionDelta struct {
+	ID            string    `json:"id" db:"id"`
+	ProjectID     string    `json:"project_id" db:"project_id"`
+	FromVersionID string    `json:"from_version_id" db:"from_version_id"`
+	ToVersionID   string    `json:"to_version_id" db:"to_version_id"`
+	PageSize      int       `json:"page_size" db:"page_size"`
+	ChangedPages  int       `json:"changed_pages" db:"changed_pages"`
+	DeltaSize     int64     `json:"delta_size" db:"delta_size"`
+	KeyID         string    `json:"key_id" db:"key_id"`
+	OSSKey        string    `json:"-" db:"oss_key"`
+	CreatedAt     time.Time `json:"created_at" db:"created_at"`
+}
+
+// 分片上传会话状态
+const (
+	UploadStatusPending   = "pending"
+	UploadStatusCompleted = "completed"
+	UploadStatusAborted   = "aborted"
+	UploadStatusExpired   = "expired" // 超过有效期未完成，分片已清理
+)
+
+// UploadSession 分片上传会话模型
+type UploadSession struct {
+	ID           string            `json:"id" db:"id"`
+	ProjectID    string            `json:"project_id" db:"project_id"`
+	CredentialID string            `json:"-" db:"credential_id"`
+	FileName     string            `json:"file_name" db:"file_name"`
+	Description  string            `json:"description" db:"description"`
+	Label        string            `json:"label" db:"label"`
+	Metadata     map[string]string `json:"metadata,omitempty" db:"metadata"` // 以 JSON 保存，完成合并时写入版本
+	Status       string            `json:"status" db:"status"`
+	VersionID    string            `json:"version_id,omitempty" db:"version_id"` // 完成后生成的版本ID
+	Parts        []*UploadPart     `json:"parts,omitempty"`
+	CreatedAt    time.Time         `json:"created_at" db:"created_at"`
+	UpdatedAt    time.Time         `json:"updated_at" db:"updated_at"`
+}
+
+// UploadPart 已上传的分片
+type UploadPart struct {
+	SessionID  string    `json:"-" db:"session_id"`
+	PartNumber int       `json:"part_number" db:"part_number"`
+	Size       int64     `json:"size" db:"size"`
+	PartHash   string    `json:"part_hash" db:"part_hash"`
+	OSSKey     string    `json:"-" db:"oss_key"`
+	CreatedAt  time.Time `json:"created_at" db:"created_at"`
 }
 
 // Pagination 分页结构
@@ -1,21 +1,26 @@
 package oss
 
 import (
-	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"strconv"
 	"time"
 
+	"chchma.com/cloudlite-sync/internal/storage"
 	"github.com/aliyun/aliyun-oss-go-sdk/oss"
 )
 
+// OSSClient 阿里云OSS存储驱动，实现 storage.Storage
 type OSSClient struct {
 	client     *oss.Client
 	bucket     *oss.Bucket
 	bucketName string
 }
 
+var _ storage.Storage = (*OSSClient)(nil)
+
 type OSSConfig struct {
 	Endpoint        string
 	AccessKeyID     string
@@ -43,36 +48,54 @@ func NewOSSClient(config OSSConfig) (*OSSClient, error) {
 	}, nil
 }
 
-// UploadFile 上传文件到OSS
-func (c *OSSClient) UploadFile(key string, data []byte) error {
-	// 上传文件
-	err := c.bucket.PutObject(key, bytes.NewReader(data))
+// isNotFound 判断OSS返回的错误是否为对象不存在
+func isNotFound(err error) bool {
+	var serviceErr oss.ServiceError
+	if errors.As(err, &serviceErr) {
+		return serviceErr.StatusCode == http.StatusNotFound
+	}
+	return false
+}
+
+// Put 上传文件到OSS
+func (c *OSSClient) Put(key string, r io.Reader) error {
+	err := c.bucket.PutObject(key, r)
 	if err != nil {
 		return fmt.Errorf("failed to upload file to OSS: %w", err)
 	}
 	return nil
 }
 
-// DownloadFile 从OSS下载文件
-func (c *OSSClient) DownloadFile(key string) ([]byte, error) {
-	// 下载文件
+// Get 从OSS读取文件
+func (c *OSSClient) Get(key string) (io.ReadCloser, error) {
 	object, err := c.bucket.GetObject(key)
 	if err != nil {
+		if isNotFound(err) {
+			return nil, storage.ErrNotFound
+		}
 		return nil, fmt.Errorf("failed to get object from OSS: %w", err)
 	}
-	defer object.Close()
+	return object, nil
+}
 
-	// 读取文件内容
-	data, err := io.ReadAll(object)
+// GetRange 从OSS读取文件的指定区间
+func (c *OSSClient) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	rangeOption := oss.NormalizedRange(fmt.Sprintf("%d-", offset))
+	if length >= 0 {
+		rangeOption = oss.Range(offset, offset+length-1)
+	}
+	object, err := c.bucket.GetObject(key, rangeOption)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read object data: %w", err)
+		if isNotFound(err) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get object from OSS: %w", err)
 	}
-
-	return data, nil
+	return object, nil
 }
 
-// DeleteFile 从OSS删除文件
-func (c *OSSClient) DeleteFile(key string) error {
+// Delete 从OSS删除文件
+func (c *OSSClient) Delete(key string) error {
 	err := c.bucket.DeleteObject(key)
 	if err != nil {
 		return fmt.Errorf("failed to delete object from OSS: %w", err)
@@ -80,8 +103,8 @@ func (c *OSSClient) DeleteFile(key string) error {
 	return nil
 }
 
-// FileExists 检查文件是否存在
-func (c *OSSClient) FileExists(key string) (bool, error) {
+// Exists 检查文件是否存在
+func (c *OSSClient) Exists(key string) (bool, error) {
 	exist, err := c.bucket.IsObjectExist(key)
 	if err != nil {
 		return false, fmt.Errorf("failed to check if object exists: %w", err)
@@ -89,8 +112,8 @@ func (c *OSSClient) FileExists(key string) (bool, error) {
 	return exist, nil
 }
 
-// GetFileURL 获取文件的预签名URL（用于直接下载）
-func (c *OSSClient) GetFileURL(key string, expires time.Duration) (string, error) {
+// SignURL 获取文件的预签名URL（用于直接下载）
+func (c *OSSClient) SignURL(key string, expires time.Duration) (string, error) {
 	url, err := c.bucket.SignURL(key, oss.HTTPGet, int64(expires.Seconds()))
 	if err != nil {
 		return "", fmt.Errorf("failed to generate signed URL: %w", err)
@@ -98,11 +121,44 @@ func (c *OSSClient) GetFileURL(key string, expires time.Duration) (string, error
 	return url, nil
 }
 
-// GetFileInfo 获取文件信息
-func (c *OSSClient) GetFileInfo(key string) (http.Header, error) {
-	meta, err := c.bucket.GetObjectDetailedMeta(key)
+// Stat 获取文件信息
+func (c *OSSClient) Stat(key string) (*storage.ObjectInfo, error) {
+	meta, err := c.bucket.GetObjectMeta(key)
 	if err != nil {
+		if isNotFound(err) {
+			return nil, storage.ErrNotFound
+		}
 		return nil, fmt.Errorf("failed to get object meta: %w", err)
 	}
-	return meta, nil
+
+	size, _ := strconv.ParseInt(meta.Get(oss.HTTPHeaderContentLength), 10, 64)
+	lastModified, _ := http.ParseTime(meta.Get(oss.HTTPHeaderLastModified))
+	return &storage.ObjectInfo{
+		Key:          key,
+		Size:         size,
+		LastModified: lastModified,
+	}, nil
+}
+
+// List 分页列出键以 prefix 开头的文件
+func (c *OSSClient) List(prefix string) ([]*storage.ObjectInfo, error) {
+	var objects []*storage.ObjectInfo
+	options := []oss.Option{oss.Prefix(prefix), oss.MaxKeys(1000)}
+	for {
+		result, err := c.bucket.ListObjectsV2(options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list objects from OSS: %w", err)
+		}
+		for _, object := range result.Objects {
+			objects = append(objects, &storage.ObjectInfo{
+				Key:          object.Key,
+				Size:         object.Size,
+				LastModified: object.LastModified,
+			})
+		}
+		if !result.IsTruncated {
+			return objects, nil
+		}
+		options = []oss.Option{oss.Prefix(prefix), oss.MaxKeys(1000), oss.ContinuationToken(result.NextContinuationToken)}
+	}
 }
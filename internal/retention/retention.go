@@ -0,0 +1,83 @@
+// Package retention 根据项目的保留策略决定哪些版本可以清理。
+package retention
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/models"
+)
+
+// Policy 保留策略。满足任一条件的版本都会保留，最新版本、固定的版本和渠道指向的版本总是保留
+type Policy struct {
+	KeepLast int `json:"keep_last"` // 保留最近的 N 个版本，0 表示不按数量保留
+	KeepDays int `json:"keep_days"` // 保留最近 D 天内创建的版本，0 表示不按时间保留
+}
+
+// ProjectPolicy 读取项目的保留策略
+func ProjectPolicy(project *models.Project) Policy {
+	return Policy{KeepLast: project.RetainCount, KeepDays: project.RetainDays}
+}
+
+// Enabled 是否设置了保留策略，未设置时不清理任何版本
+func (p Policy) Enabled() bool {
+	return p.KeepLast > 0 || p.KeepDays > 0
+}
+
+// Decision 单个版本的处理结果
+type Decision struct {
+	Version *models.DatabaseVersion `json:"version"`
+	Reason  string                  `json:"reason"`
+}
+
+// Plan 将版本分为保留和清理两组，两组都按创建时间从新到旧排列。
+// protected 为其他原因必须保留的版本ID及原因，例如被渠道引用
+func Plan(versions []*models.DatabaseVersion, policy Policy, protected map[string]string, now time.Time) (keep, prune []*Decision) {
+	sorted := append([]*models.DatabaseVersion(nil), versions...)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].CreatedAt.After(sorted[j].CreatedAt)
+	})
+
+	cutoff := now.AddDate(0, 0, -policy.KeepDays)
+	for i, v := range sorted {
+		var reasons []string
+		if v.IsLatest {
+			reasons = append(reasons, "latest")
+		}
+		if v.Pinned {
+			reasons = append(reasons, "pinned")
+		}
+		if reason, ok := protected[v.ID]; ok {
+			reasons = append(reasons, reason)
+		}
+		if !policy.Enabled() {
+			reasons = append(reasons, "no retention policy")
+		}
+		if policy.KeepLast > 0 && i < policy.KeepLast {
+			reasons = append(reasons, fmt.Sprintf("within last %d versions", policy.KeepLast))
+		}
+		if policy.KeepDays > 0 && v.CreatedAt.After(cutoff) {
+			reasons = append(reasons, fmt.Sprintf("newer than %d days", policy.KeepDays))
+		}
+
+		if len(reasons) > 0 {
+			keep = append(keep, &Decision{Version: v, Reason: strings.Join(reasons, ", ")})
+			continue
+		}
+		prune = append(prune, &Decision{Version: v, Reason: pruneReason(policy)})
+	}
+	return keep, prune
+}
+
+func pruneReason(policy Policy) string {
+	var reasons []string
+	if policy.KeepLast > 0 {
+		reasons = append(reasons, fmt.Sprintf("beyond last %d versions", policy.KeepLast))
+	}
+	if policy.KeepDays > 0 {
+		reasons = append(reasons, fmt.Sprintf("older than %d days", policy.KeepDays))
+	}
+	return strings.Join(reasons, " and ")
+}
@@ -0,0 +1,192 @@
+package s3
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"chchma.com/cloudlite-sync/internal/storage"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// partSize 未知长度上传时的分片大小，决定单次上传占用的内存
+const partSize = 16 << 20
+
+// S3Client S3 协议存储驱动（AWS S3、MinIO、Cloudflare R2 等），实现 storage.Storage
+type S3Client struct {
+	client     *minio.Client
+	bucketName string
+}
+
+var _ storage.Storage = (*S3Client)(nil)
+
+type S3Config struct {
+	Endpoint        string // 可带 http:// 或 https:// 前缀，不带时默认使用 https
+	Region          string
+	AccessKeyID     string
+	AccessKeySecret string
+	BucketName      string
+	PathStyle       bool // 使用路径风格访问（MinIO 等自建服务通常需要开启）
+}
+
+func NewS3Client(config S3Config) (*S3Client, error) {
+	endpoint := config.Endpoint
+	secure := true
+	switch {
+	case strings.HasPrefix(endpoint, "http://"):
+		endpoint = strings.TrimPrefix(endpoint, "http://")
+		secure = false
+	case strings.HasPrefix(endpoint, "https://"):
+		endpoint = strings.TrimPrefix(endpoint, "https://")
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+
+	lookup := minio.BucketLookupAuto
+	if config.PathStyle {
+		lookup = minio.BucketLookupPath
+	}
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds:        credentials.NewStaticV4(config.AccessKeyID, config.AccessKeySecret, ""),
+		Secure:       secure,
+		Region:       config.Region,
+		BucketLookup: lookup,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create S3 client: %w", err)
+	}
+
+	return &S3Client{
+		client:     client,
+		bucketName: config.BucketName,
+	}, nil
+}
+
+// isNotFound 判断S3返回的错误是否为对象不存在
+func isNotFound(err error) bool {
+	resp := minio.ToErrorResponse(err)
+	return resp.StatusCode == http.StatusNotFound || resp.Code == "NoSuchKey"
+}
+
+// Put 上传文件到S3
+func (c *S3Client) Put(key string, r io.Reader) error {
+	_, err := c.client.PutObject(context.Background(), c.bucketName, key, r, -1, minio.PutObjectOptions{
+		ContentType: "application/octet-stream",
+		PartSize:    partSize,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload file to S3: %w", err)
+	}
+	return nil
+}
+
+// Get 从S3读取文件
+func (c *S3Client) Get(key string) (io.ReadCloser, error) {
+	return c.getObject(key, minio.GetObjectOptions{})
+}
+
+// GetRange 从S3读取文件的指定区间
+func (c *S3Client) GetRange(key string, offset, length int64) (io.ReadCloser, error) {
+	opts := minio.GetObjectOptions{}
+	var err error
+	switch {
+	case length >= 0:
+		err = opts.SetRange(offset, offset+length-1)
+	case offset > 0:
+		err = opts.SetRange(offset, 0)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid range: %w", err)
+	}
+	return c.getObject(key, opts)
+}
+
+func (c *S3Client) getObject(key string, opts minio.GetObjectOptions) (io.ReadCloser, error) {
+	object, err := c.client.GetObject(context.Background(), c.bucketName, key, opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get object from S3: %w", err)
+	}
+	// GetObject 是惰性的，先 Stat 一次以便及时发现对象不存在
+	if _, err := object.Stat(); err != nil {
+		object.Close()
+		if isNotFound(err) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get object from S3: %w", err)
+	}
+	return object, nil
+}
+
+// Delete 从S3删除文件
+func (c *S3Client) Delete(key string) error {
+	err := c.client.RemoveObject(context.Background(), c.bucketName, key, minio.RemoveObjectOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to delete object from S3: %w", err)
+	}
+	return nil
+}
+
+// Exists 检查文件是否存在
+func (c *S3Client) Exists(key string) (bool, error) {
+	_, err := c.Stat(key)
+	if err != nil {
+		if errors.Is(err, storage.ErrNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// SignURL 获取文件的预签名URL（用于直接下载）
+func (c *S3Client) SignURL(key string, expires time.Duration) (string, error) {
+	u, err := c.client.PresignedGetObject(context.Background(), c.bucketName, key, expires, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate signed URL: %w", err)
+	}
+	return u.String(), nil
+}
+
+// Stat 获取文件信息
+func (c *S3Client) Stat(key string) (*storage.ObjectInfo, error) {
+	info, err := c.client.StatObject(context.Background(), c.bucketName, key, minio.StatObjectOptions{})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, storage.ErrNotFound
+		}
+		return nil, fmt.Errorf("failed to get object meta: %w", err)
+	}
+	return &storage.ObjectInfo{
+		Key:          key,
+		Size:         info.Size,
+		LastModified: info.LastModified,
+	}, nil
+}
+
+// List 列出键以 prefix 开头的文件
+func (c *S3Client) List(prefix string) ([]*storage.ObjectInfo, error) {
+	// 提前返回时取消上下文，结束 ListObjects 的后台协程
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var objects []*storage.ObjectInfo
+	for object := range c.client.ListObjects(ctx, c.bucketName, minio.ListObjectsOptions{
+		Prefix:    prefix,
+		Recursive: true,
+	}) {
+		if object.Err != nil {
+			return nil, fmt.Errorf("failed to list objects from S3: %w", object.Err)
+		}
+		objects = append(objects, &storage.ObjectInfo{
+			Key:          object.Key,
+			Size:         object.Size,
+			LastModified: object.LastModified,
+		})
+	}
+	return objects, nil
+}
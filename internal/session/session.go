@@ -2,9 +2,11 @@ package session
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
 	"log"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/gorilla/sessions"
@@ -18,7 +20,10 @@ func Init(secretKey string) {
 	if err != nil {
 		panic("session_secret 不是合法的 base64 字符串")
 	}
-	store = sessions.NewCookieStore(key)
+	// 由同一密钥派生加密密钥，Cookie 内容既签名又加密；
+	// 第二组只签名的密钥用于读取升级前签发的 Cookie，保存时按第一组重新加密
+	encryptionKey := sha256.Sum256(append([]byte("cloudlite-session-encryption:"), key...))
+	store = sessions.NewCookieStore(key, encryptionKey[:], key, nil)
 	store.Options = &sessions.Options{
 		Path:     "/",
 		MaxAge:   86400 * 7, // 7天
@@ -27,9 +32,14 @@ func Init(secretKey string) {
 	}
 }
 
-// GetSession 获取会话
+// GetSession 获取会话，Cookie 无法解码（如密钥更换）时返回新的空会话
 func GetSession(r *http.Request) (*sessions.Session, error) {
-	return store.Get(r, "db-sync-session")
+	session, err := store.Get(r, "db-sync-session")
+	if err != nil && session != nil {
+		log.Println("GetSession: discarding undecodable session cookie:", err)
+		return session, nil
+	}
+	return session, err
 }
 
 // SetAuthenticated 设置认证状态
@@ -87,6 +97,87 @@ func ClearSession(w http.ResponseWriter, r *http.Request) error {
 	return session.Save(r, w)
 }
 
+// AddFlash 保存一条只在下次请求中读取一次的消息
+func AddFlash(w http.ResponseWriter, r *http.Request, key, value string) error {
+	session, err := GetSession(r)
+	if err != nil {
+		return err
+	}
+
+	session.AddFlash(value, key)
+	return session.Save(r, w)
+}
+
+// PopFlash 读取并清除消息，必须在写入响应体之前调用
+func PopFlash(w http.ResponseWriter, r *http.Request, key string) string {
+	session, err := GetSession(r)
+	if err != nil {
+		return ""
+	}
+
+	flashes := session.Flashes(key)
+	if len(flashes) == 0 {
+		return ""
+	}
+	if err := session.Save(r, w); err != nil {
+		log.Println("PopFlash error:", err)
+		return ""
+	}
+
+	value, _ := flashes[len(flashes)-1].(string)
+	return value
+}
+
+// secretFlashTTL 一次性秘密在服务端保存的时间
+const secretFlashTTL = 5 * time.Minute
+
+// secretFlash 保存在服务端的一次性秘密
+type secretFlash struct {
+	value     string
+	expiresAt time.Time
+}
+
+var (
+	secretFlashes = make(map[string]secretFlash)
+	secretMutex   sync.Mutex
+)
+
+// AddSecretFlash 将秘密（如新建凭证的完整令牌）保存在服务端，会话 Cookie 中只保存随机ID，
+// 由下次请求通过 PopSecretFlash 读取一次
+func AddSecretFlash(w http.ResponseWriter, r *http.Request, key, value string) error {
+	id := GenerateSecretKey()
+	now := time.Now()
+
+	secretMutex.Lock()
+	for other, secret := range secretFlashes {
+		if now.After(secret.expiresAt) {
+			delete(secretFlashes, other)
+		}
+	}
+	secretFlashes[id] = secretFlash{value: value, expiresAt: now.Add(secretFlashTTL)}
+	secretMutex.Unlock()
+
+	return AddFlash(w, r, key, id)
+}
+
+// PopSecretFlash 读取并删除 AddSecretFlash 保存的秘密，已读取或过期时返回空字符串，必须在写入响应体之前调用
+func PopSecretFlash(w http.ResponseWriter, r *http.Request, key string) string {
+	id := PopFlash(w, r, key)
+	if id == "" {
+		return ""
+	}
+
+	secretMutex.Lock()
+	secret, ok := secretFlashes[id]
+	delete(secretFlashes, id)
+	secretMutex.Unlock()
+
+	if !ok || time.Now().After(secret.expiresAt) {
+		return ""
+	}
+	return secret.value
+}
+
 // GenerateSecretKey 生成密钥
 func GenerateSecretKey() string {
 	b := make([]byte, 32)
@@ -1,10 +1,10 @@
 package session
 
 import (
-	"crypto/rand"
-	"fmt"
 	"sync"
 	"time"
+
+	"chchma.com/cloudlite-sync/internal/idgen"
 )
 
 // ShareCode 分享码结构
@@ -51,34 +51,31 @@ func (s *ShareCodeService) GetExpireSeconds() int {
 	return s.expireSeconds
 }
 
-// GenerateShareCode 生成6位数字分享码
+// GenerateShareCode 生成6位数字分享码，与未过期的分享码重复时重新生成
 func (s *ShareCodeService) GenerateShareCode(token string) (string, error) {
-	// 生成6位随机数字
-	code := ""
-	for i := 0; i < 6; i++ {
-		randomByte := make([]byte, 1)
-		_, err := rand.Read(randomByte)
-		if err != nil {
-			return "", err
-		}
-		code += fmt.Sprintf("%d", randomByte[0]%10)
-	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
 
-	// 创建分享码记录
-	now := time.Now()
-	shareCode := &ShareCode{
-		Code:      code,
-		Token:     token,
-		CreatedAt: now,
-		ExpiresAt: now.Add(time.Duration(s.expireSeconds) * time.Second),
+	var shareCode *ShareCode
+	err := idgen.ShareCodes.Insert(func(code string) error {
+		if _, exists := s.codes[code]; exists {
+			return idgen.ErrCollision
+		}
+		now := time.Now()
+		shareCode = &ShareCode{
+			Code:      code,
+			Token:     token,
+			CreatedAt: now,
+			ExpiresAt: now.Add(time.Duration(s.expireSeconds) * time.Second),
+		}
+		s.codes[code] = shareCode
+		return nil
+	})
+	if err != nil {
+		return "", err
 	}
 
-	// 存储到内存中
-	s.mutex.Lock()
-	s.codes[code] = shareCode
-	s.mutex.Unlock()
-
-	return code, nil
+	return shareCode.Code, nil
 }
 
 // GetTokenByCode 根据分享码获取令牌
@@ -0,0 +1,226 @@
+// Package sqlitediff 比较两个 SQLite 数据库文件的结构和数据规模，
+// 用于在发布前检查一次上传改动了哪些表。
+package sqlitediff
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// 对象和表的变化状态
+const (
+	StatusAdded     = "added"
+	StatusDropped   = "dropped"
+	StatusModified  = "modified"
+	StatusUnchanged = "unchanged"
+)
+
+// Object sqlite_master 中的一条记录（表、索引、视图或触发器）
+type Object struct {
+	Type  string `json:"type"`
+	Name  string `json:"name"`
+	Table string `json:"table"`
+	SQL   string `json:"sql"`
+}
+
+// Snapshot 数据库文件的结构快照
+type Snapshot struct {
+	PageSize    int64              `json:"page_size"`
+	PageCount   int64              `json:"page_count"`
+	UserVersion int64              `json:"user_version"`
+	Objects     map[string]*Object `json:"-"`
+	// RowCounts 各表行数，无法统计的表（例如缺少模块的虚拟表）为 -1
+	RowCounts map[string]int64 `json:"-"`
+}
+
+// TableDiff 单个表的差异
+type TableDiff struct {
+	Name     string `json:"name"`
+	Status   string `json:"status"`
+	OldSQL   string `json:"old_sql,omitempty"`
+	NewSQL   string `json:"new_sql,omitempty"`
+	OldRows  int64  `json:"old_rows"`
+	NewRows  int64  `json:"new_rows"`
+	RowDelta int64  `json:"row_delta"`
+}
+
+// ObjectDiff 索引、视图、触发器的差异，只包含有变化的对象
+type ObjectDiff struct {
+	Type   string `json:"type"`
+	Name   string `json:"name"`
+	Table  string `json:"table"`
+	Status string `json:"status"`
+	OldSQL string `json:"old_sql,omitempty"`
+	NewSQL string `json:"new_sql,omitempty"`
+}
+
+// Summary 差异汇总
+type Summary struct {
+	TablesAdded    int   `json:"tables_added"`
+	TablesDropped  int   `json:"tables_dropped"`
+	TablesModified int   `json:"tables_modified"`
+	RowsChanged    int   `json:"row_changed_tables"`
+	ObjectsChanged int   `json:"objects_changed"`
+	RowDelta       int64 `json:"row_delta"`
+}
+
+// Diff 两个数据库文件的差异
+type Diff struct {
+	From    *Snapshot     `json:"from"`
+	To      *Snapshot     `json:"to"`
+	Tables  []*TableDiff  `json:"tables"`
+	Objects []*ObjectDiff `json:"objects"`
+	Summary Summary       `json:"summary"`
+}
+
+// Inspect 以只读方式打开数据库文件并读取结构和各表行数
+func Inspect(path string) (*Snapshot, error) {
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?mode=ro&immutable=1"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	snapshot := &Snapshot{
+		Objects:   make(map[string]*Object),
+		RowCounts: make(map[string]int64),
+	}
+	for pragma, dest := range map[string]*int64{
+		"page_size":    &snapshot.PageSize,
+		"page_count":   &snapshot.PageCount,
+		"user_version": &snapshot.UserVersion,
+	} {
+		if err := db.QueryRow("PRAGMA " + pragma).Scan(dest); err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", pragma, err)
+		}
+	}
+
+	rows, err := db.Query(`SELECT type, name, tbl_name, COALESCE(sql, '') FROM sqlite_master
+		WHERE name NOT LIKE 'sqlite\_%' ESCAPE '\'`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read schema: %w", err)
+	}
+	var tables []string
+	for rows.Next() {
+		object := &Object{}
+		if err := rows.Scan(&object.Type, &object.Name, &object.Table, &object.SQL); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		snapshot.Objects[object.Type+":"+object.Name] = object
+		if object.Type == "table" {
+			tables = append(tables, object.Name)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, table := range tables {
+		var count int64
+		query := `SELECT COUNT(*) FROM "` + strings.ReplaceAll(table, `"`, `""`) + `"`
+		if err := db.QueryRow(query).Scan(&count); err != nil {
+			count = -1
+		}
+		snapshot.RowCounts[table] = count
+	}
+	return snapshot, nil
+}
+
+// Compare 比较两个快照，结果按名称排序
+func Compare(from, to *Snapshot) *Diff {
+	diff := &Diff{From: from, To: to, Tables: []*TableDiff{}, Objects: []*ObjectDiff{}}
+
+	keys := make(map[string]bool)
+	for key := range from.Objects {
+		keys[key] = true
+	}
+	for key := range to.Objects {
+		keys[key] = true
+	}
+
+	for key := range keys {
+		oldObject, newObject := from.Objects[key], to.Objects[key]
+		object := newObject
+		if object == nil {
+			object = oldObject
+		}
+
+		status := StatusUnchanged
+		var oldSQL, newSQL string
+		switch {
+		case oldObject == nil:
+			status = StatusAdded
+			newSQL = newObject.SQL
+		case newObject == nil:
+			status = StatusDropped
+			oldSQL = oldObject.SQL
+		case normalizeSQL(oldObject.SQL) != normalizeSQL(newObject.SQL):
+			status = StatusModified
+			oldSQL, newSQL = oldObject.SQL, newObject.SQL
+		}
+
+		if object.Type != "table" {
+			if status != StatusUnchanged {
+				diff.Objects = append(diff.Objects, &ObjectDiff{
+					Type:   object.Type,
+					Name:   object.Name,
+					Table:  object.Table,
+					Status: status,
+					OldSQL: oldSQL,
+					NewSQL: newSQL,
+				})
+				diff.Summary.ObjectsChanged++
+			}
+			continue
+		}
+
+		table := &TableDiff{Name: object.Name, Status: status, OldSQL: oldSQL, NewSQL: newSQL}
+		if oldObject != nil {
+			table.OldRows = from.RowCounts[object.Name]
+		}
+		if newObject != nil {
+			table.NewRows = to.RowCounts[object.Name]
+		}
+		if table.OldRows >= 0 && table.NewRows >= 0 {
+			table.RowDelta = table.NewRows - table.OldRows
+		}
+		diff.Tables = append(diff.Tables, table)
+
+		switch status {
+		case StatusAdded:
+			diff.Summary.TablesAdded++
+		case StatusDropped:
+			diff.Summary.TablesDropped++
+		case StatusModified:
+			diff.Summary.TablesModified++
+		}
+		if table.RowDelta != 0 {
+			diff.Summary.RowsChanged++
+			diff.Summary.RowDelta += table.RowDelta
+		}
+	}
+
+	sort.Slice(diff.Tables, func(i, j int) bool {
+		return diff.Tables[i].Name < diff.Tables[j].Name
+	})
+	sort.Slice(diff.Objects, func(i, j int) bool {
+		if diff.Objects[i].Type != diff.Objects[j].Type {
+			return diff.Objects[i].Type < diff.Objects[j].Type
+		}
+		return diff.Objects[i].Name < diff.Objects[j].Name
+	})
+	return diff
+}
+
+// normalizeSQL 忽略空白差异
+func normalizeSQL(s string) string {
+	return strings.Join(strings.Fields(s), " ")
+}
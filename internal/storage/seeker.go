@@ -0,0 +1,148 @@
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+// ReadSeeker 基于 GetRange 实现 io.ReadSeeker，Seek 只记录位置，
+// 读取位置与当前连接不一致时才重新发起区间请求，供 http.ServeContent 处理 Range 请求
+type ReadSeeker struct {
+	storage    Storage
+	key        string
+	size       int64
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+// OpenReadSeeker 打开对象并返回可定位的读取器，size 为对象总长度。
+// 会立即发起一次读取请求，以便在写出响应头之前发现对象不存在等错误。
+func OpenReadSeeker(s Storage, key string, size int64) (*ReadSeeker, error) {
+	body, err := s.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return &ReadSeeker{storage: s, key: key, size: size, body: body}, nil
+}
+
+func (rs *ReadSeeker) Read(p []byte) (int, error) {
+	if rs.offset >= rs.size {
+		return 0, io.EOF
+	}
+	if rs.body != nil && rs.bodyOffset != rs.offset {
+		rs.body.Close()
+		rs.body = nil
+	}
+	if rs.body == nil {
+		body, err := rs.storage.GetRange(rs.key, rs.offset, -1)
+		if err != nil {
+			return 0, err
+		}
+		rs.body = body
+		rs.bodyOffset = rs.offset
+	}
+
+	n, err := rs.body.Read(p)
+	rs.offset += int64(n)
+	rs.bodyOffset += int64(n)
+	return n, err
+}
+
+func (rs *ReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rs.offset
+	case io.SeekEnd:
+		offset += rs.size
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	rs.offset = offset
+	return offset, nil
+}
+
+func (rs *ReadSeeker) Close() error {
+	if rs.body != nil {
+		return rs.body.Close()
+	}
+	return nil
+}
+
+// StreamSeeker 在只能顺序读取的流（如解压、解密后的内容）上模拟 Seek：
+// 向前定位时丢弃中间内容，向后定位时重新打开。供 http.ServeContent 处理 Range 请求。
+type StreamSeeker struct {
+	open       func() (io.ReadCloser, error)
+	size       int64
+	offset     int64
+	body       io.ReadCloser
+	bodyOffset int64
+}
+
+// NewStreamSeeker 创建 StreamSeeker，size 为流的总长度，open 每次返回从头开始的流
+func NewStreamSeeker(open func() (io.ReadCloser, error), size int64) (*StreamSeeker, error) {
+	// 立即打开一次，以便在写出响应头之前发现对象不存在等错误
+	body, err := open()
+	if err != nil {
+		return nil, err
+	}
+	return &StreamSeeker{open: open, size: size, body: body}, nil
+}
+
+func (rs *StreamSeeker) Read(p []byte) (int, error) {
+	if rs.offset >= rs.size {
+		return 0, io.EOF
+	}
+	if rs.body != nil && rs.bodyOffset > rs.offset {
+		rs.body.Close()
+		rs.body = nil
+	}
+	if rs.body == nil {
+		body, err := rs.open()
+		if err != nil {
+			return 0, err
+		}
+		rs.body = body
+		rs.bodyOffset = 0
+	}
+	if skip := rs.offset - rs.bodyOffset; skip > 0 {
+		n, err := io.CopyN(io.Discard, rs.body, skip)
+		rs.bodyOffset += n
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rs.body.Read(p)
+	rs.offset += int64(n)
+	rs.bodyOffset += int64(n)
+	return n, err
+}
+
+func (rs *StreamSeeker) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += rs.offset
+	case io.SeekEnd:
+		offset += rs.size
+	default:
+		return 0, errors.New("storage: invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("storage: negative position")
+	}
+	rs.offset = offset
+	return offset, nil
+}
+
+func (rs *StreamSeeker) Close() error {
+	if rs.body != nil {
+		return rs.body.Close()
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+var (
+	// ErrNotFound 对象不存在
+	ErrNotFound = errors.New("storage: object not found")
+	// ErrNotSupported 当前存储驱动不支持该操作
+	ErrNotSupported = errors.New("storage: operation not supported")
+)
+
+// 存储驱动名称
+const (
+	DriverOSS   = "oss"
+	DriverS3    = "s3"
+	DriverLocal = "local"
+)
+
+// ObjectInfo 对象元信息
+type ObjectInfo struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// Storage 数据库文件的存储后端
+type Storage interface {
+	// Put 写入对象，已存在时覆盖
+	Put(key string, r io.Reader) error
+	// Get 读取对象，调用方负责关闭
+	Get(key string) (io.ReadCloser, error)
+	// GetRange 从 offset 开始读取 length 字节，length 小于0时读取到末尾
+	GetRange(key string, offset, length int64) (io.ReadCloser, error)
+	// Delete 删除对象，对象不存在时不报错
+	Delete(key string) error
+	// Exists 检查对象是否存在
+	Exists(key string) (bool, error)
+	// SignURL 生成带有效期的直接下载地址
+	SignURL(key string, expires time.Duration) (string, error)
+	// Stat 获取对象元信息
+	Stat(key string) (*ObjectInfo, error)
+	// List 列出键以 prefix 开头的全部对象
+	List(prefix string) ([]*ObjectInfo, error)
+}
@@ -2,12 +2,16 @@ package utils
 
 import (
 	"crypto/md5"
+	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
-	"math/rand"
+	"hash"
+	"io"
+	"net"
 	"strings"
 	"time"
 
+	"chchma.com/cloudlite-sync/internal/idgen"
 	"github.com/google/uuid"
 )
 
@@ -21,25 +25,57 @@ func GenerateHash(data []byte) string {
 	return hex.EncodeToString(hash[:])
 }
 
+// HashReader 在读取的同时计算MD5、SHA-256哈希和已读字节数，用于流式上传
+type HashReader struct {
+	r      io.Reader
+	hash   hash.Hash
+	sha256 hash.Hash
+	size   int64
+}
+
+// NewHashReader 包装 r，读取时同步计算哈希
+func NewHashReader(r io.Reader) *HashReader {
+	return &HashReader{r: r, hash: md5.New(), sha256: sha256.New()}
+}
+
+func (hr *HashReader) Read(p []byte) (int, error) {
+	n, err := hr.r.Read(p)
+	if n > 0 {
+		hr.hash.Write(p[:n])
+		hr.sha256.Write(p[:n])
+		hr.size += int64(n)
+	}
+	return n, err
+}
+
+// Hash 返回已读内容的MD5哈希，应在读取完毕后调用
+func (hr *HashReader) Hash() string {
+	return hex.EncodeToString(hr.hash.Sum(nil))
+}
+
+// SHA256 返回已读内容的SHA-256哈希（十六进制），应在读取完毕后调用
+func (hr *HashReader) SHA256() string {
+	return hex.EncodeToString(hr.sha256.Sum(nil))
+}
+
+// Size 返回已读字节数
+func (hr *HashReader) Size() int64 {
+	return hr.size
+}
+
 // GenerateUUID 生成UUID
 func GenerateUUID() string {
 	return uuid.New().String()
 }
 
-// GenerateVersion 生成版本号（基于时间戳）
+// GenerateVersion 生成版本号（基于时间戳）。同一秒内的版本号相同，写入时由数据库追加序号区分
 func GenerateVersion() string {
 	return fmt.Sprintf("%d", time.Now().Unix())
 }
 
-// GenerateRandomString 生成随机字符串
+// GenerateRandomString 生成由大写字母和数字组成的随机字符串
 func GenerateRandomString(length int) string {
-	const charset = "ABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	b := make([]byte, length)
-	for i := range b {
-		b[i] = charset[r.Intn(len(charset))]
-	}
-	return string(b)
+	return idgen.MustNew(idgen.UpperDigits, length).Generate()
 }
 
 // FormatFileSize 格式化文件大小
@@ -61,7 +97,75 @@ func FormatTime(t time.Time) string {
 	return t.Format("2006-01-02 15:04:05")
 }
 
-// GenerateOSSKey 生成OSS存储键
-func GenerateOSSKey(projectID, version, fileName string) string {
-	return fmt.Sprintf("store/%s/%s/%s", projectID, version, fileName)
+// GenerateOSSKey 生成OSS存储键，使用版本记录的ID以免同一秒内上传的版本互相覆盖
+func GenerateOSSKey(projectID, versionID, fileName string) string {
+	return fmt.Sprintf("store/%s/%s/%s", projectID, versionID, fileName)
+}
+
+// GenerateBlobKey 生成按内容 SHA-256 寻址的共享对象存储键，取前两位分目录避免单个目录下对象过多
+func GenerateBlobKey(sha256Hash string) string {
+	return fmt.Sprintf("blobs/%s/%s", sha256Hash[:2], sha256Hash)
+}
+
+// GenerateUploadPartKey 生成分片上传临时对象的存储键
+func GenerateUploadPartKey(projectID, sessionID string, partNumber int) string {
+	return fmt.Sprintf("uploads/%s/%s/%d", projectID, sessionID, partNumber)
+}
+
+// GenerateDeltaKey 生成版本差量的存储键
+func GenerateDeltaKey(projectID, fromHash, toHash string) string {
+	return fmt.Sprintf("deltas/%s/%s-%s.delta", projectID, fromHash, toHash)
+}
+
+// SplitList 按逗号或换行拆分列表，去掉空白项
+func SplitList(s string) []string {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '\n' || r == '\r'
+	})
+	var items []string
+	for _, field := range fields {
+		if item := strings.TrimSpace(field); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// ParseCIDRs 解析地址段列表，单个 IP 视为只包含该地址的地址段
+func ParseCIDRs(items []string) ([]*net.IPNet, error) {
+	var networks []*net.IPNet
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		if !strings.Contains(item, "/") {
+			ip := net.ParseIP(item)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid IP address %q", item)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(item)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CIDR %q", item)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ContainsIP 地址是否属于任一地址段
+func ContainsIP(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,218 @@
+// Package validator 在上传的数据库文件成为新版本之前检查其是否为完整可用的 SQLite 数据库。
+package validator
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// 完整性检查级别
+const (
+	CheckQuick = "quick" // PRAGMA quick_check，不校验索引内容，速度较快
+	CheckFull  = "full"  // PRAGMA integrity_check
+	CheckOff   = "off"   // 只检查文件头
+)
+
+// 校验失败的错误码
+const (
+	CodeEmptyFile      = "empty_file"
+	CodeInvalidHeader  = "invalid_header"
+	CodeTruncated      = "truncated"
+	CodeCorrupted      = "integrity_check_failed"
+	CodeMissingTables  = "missing_tables"
+	CodeUnreadableFile = "unreadable"
+)
+
+// maxDetails 错误详情的最大条数
+const maxDetails = 20
+
+var sqliteHeader = []byte("SQLite format 3\x00")
+
+// Options 校验选项
+type Options struct {
+	Check          string   // 完整性检查级别，为空时等同于 CheckQuick
+	RequiredTables []string // 必须存在的表
+}
+
+// Error 校验失败的结构化错误
+type Error struct {
+	Code    string   `json:"code"`
+	Message string   `json:"message"`
+	Details []string `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if len(e.Details) == 0 {
+		return e.Message
+	}
+	return e.Message + ": " + strings.Join(e.Details, "; ")
+}
+
+// Validate 依次检查文件头、完整性和必需的表，校验失败时返回 *Error
+func Validate(path string, opts Options) error {
+	if err := checkHeader(path); err != nil {
+		return err
+	}
+
+	dsn := "file:" + (&url.URL{Path: path}).EscapedPath() + "?mode=ro&immutable=1"
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	switch opts.Check {
+	case CheckOff:
+	case CheckFull:
+		if err := runCheck(db, "PRAGMA integrity_check"); err != nil {
+			return err
+		}
+	default:
+		if err := runCheck(db, "PRAGMA quick_check"); err != nil {
+			return err
+		}
+	}
+
+	if len(opts.RequiredTables) > 0 {
+		return checkTables(db, opts.RequiredTables)
+	}
+	return nil
+}
+
+// checkHeader 检查魔数、页大小，以及文件长度是否与文件头记录的页数一致
+func checkHeader(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if fi.Size() == 0 {
+		return &Error{Code: CodeEmptyFile, Message: "file is empty"}
+	}
+
+	header := make([]byte, 100)
+	if _, err := io.ReadFull(f, header); err != nil || !bytes.Equal(header[:16], sqliteHeader) {
+		return &Error{Code: CodeInvalidHeader, Message: "file is not a SQLite database"}
+	}
+
+	pageSize := int64(binary.BigEndian.Uint16(header[16:18]))
+	if pageSize == 1 {
+		pageSize = 65536
+	}
+	if pageSize < 512 || pageSize > 65536 || pageSize&(pageSize-1) != 0 {
+		return &Error{Code: CodeInvalidHeader, Message: fmt.Sprintf("invalid page size %d", pageSize)}
+	}
+	if fi.Size()%pageSize != 0 {
+		return &Error{
+			Code:    CodeTruncated,
+			Message: "file size is not a multiple of the page size",
+			Details: []string{fmt.Sprintf("size %d, page size %d", fi.Size(), pageSize)},
+		}
+	}
+
+	// 文件头中的页数只有在 change counter 与 version-valid-for 一致时才可信
+	pageCount := int64(binary.BigEndian.Uint32(header[28:32]))
+	if pageCount > 0 && bytes.Equal(header[24:28], header[92:96]) && fi.Size() < pageCount*pageSize {
+		return &Error{
+			Code:    CodeTruncated,
+			Message: "file is shorter than the page count in its header",
+			Details: []string{fmt.Sprintf("size %d, expected %d", fi.Size(), pageCount*pageSize)},
+		}
+	}
+	return nil
+}
+
+// UserVersion 读取文件头中的 user_version（偏移 60 的 4 字节大端整数），与 PRAGMA user_version 的结果相同。
+// 应用通常用它记录数据库的 schema 版本
+func UserVersion(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return ReadUserVersion(f)
+}
+
+// ReadUserVersion 从数据库文件内容的开头读取 user_version，用于读取存储中的版本文件
+func ReadUserVersion(r io.Reader) (int, error) {
+	header := make([]byte, 100)
+	if _, err := io.ReadFull(r, header); err != nil || !bytes.Equal(header[:16], sqliteHeader) {
+		return 0, &Error{Code: CodeInvalidHeader, Message: "file is not a SQLite database"}
+	}
+	return int(int32(binary.BigEndian.Uint32(header[60:64]))), nil
+}
+
+// runCheck 执行 quick_check 或 integrity_check，结果不是 ok 时返回错误详情
+func runCheck(db *sql.DB, query string) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return &Error{Code: CodeCorrupted, Message: "integrity check failed", Details: []string{err.Error()}}
+	}
+	defer rows.Close()
+
+	var details []string
+	for rows.Next() {
+		var line string
+		if err := rows.Scan(&line); err != nil {
+			return err
+		}
+		if line == "ok" {
+			continue
+		}
+		if len(details) < maxDetails {
+			details = append(details, line)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return &Error{Code: CodeCorrupted, Message: "integrity check failed", Details: append(details, err.Error())}
+	}
+	if len(details) > 0 {
+		return &Error{Code: CodeCorrupted, Message: "integrity check failed", Details: details}
+	}
+	return nil
+}
+
+// checkTables 检查必需的表是否存在
+func checkTables(db *sql.DB, tables []string) error {
+	rows, err := db.Query(`SELECT name FROM sqlite_master WHERE type = 'table'`)
+	if err != nil {
+		return &Error{Code: CodeUnreadableFile, Message: "failed to read schema", Details: []string{err.Error()}}
+	}
+	defer rows.Close()
+
+	existing := make(map[string]bool)
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		existing[strings.ToLower(name)] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, table := range tables {
+		if !existing[strings.ToLower(table)] {
+			missing = append(missing, table)
+		}
+	}
+	if len(missing) > 0 {
+		return &Error{Code: CodeMissingTables, Message: "required tables are missing", Details: missing}
+	}
+	return nil
+}